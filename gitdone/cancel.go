@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Time limits for each phase of a run, set from flags
+var phaseTimeouts = struct {
+	Generate time.Duration // Summarizing and calling the model
+	Commit   time.Duration
+	Push     time.Duration
+}{
+	Generate: timeout,
+	Commit:   30 * time.Second,
+	Push:     2 * time.Minute,
+}
+
+// Time allowed for putting things back after a cancelled run
+const rollbackTimeout = 10 * time.Second
+
+// Get a context that is cancelled by Ctrl-C or SIGTERM
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// A phase of a run with its own deadline
+type phase struct {
+	name    string
+	limit   time.Duration
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped func()
+}
+
+// Start a phase: time it in result and give it a deadline under ctx, or none if limit is 0
+func startPhase(ctx context.Context, result *runResult, name string, limit time.Duration) *phase {
+	p := &phase{name: name, limit: limit, parent: ctx, stopped: result.time(name)}
+	if limit <= 0 {
+		// Phases waiting on the user have no deadline
+		p.ctx, p.cancel = context.WithCancel(ctx)
+	} else {
+		p.ctx, p.cancel = context.WithTimeout(ctx, limit)
+	}
+	return p
+}
+
+// End the phase and classify its error: cancelled runs and missed
+// deadlines get their own exit codes, anything else gets code
+func (p *phase) end(code int, err error) error {
+	p.stopped()
+	defer p.cancel()
+	if err == nil {
+		return nil
+	}
+	if p.parent.Err() != nil {
+		return failPhase(exitInterrupted, fmt.Errorf("Interrupted during %s", p.name))
+	}
+	if p.ctx.Err() == context.DeadlineExceeded {
+		return failPhase(exitTimeout, fmt.Errorf("%s timed out after %v", p.name, p.limit))
+	}
+	// Errors that already carry an exit code keep it
+	var pe *phaseError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return failPhase(code, err)
+}
+
+// A phase that runs alongside the others, such as loading the model while git
+// stages and diffs. Its timing goes into the result when it is joined, since
+// only the run's own goroutine writes the result.
+type backgroundPhase struct {
+	name    string
+	cancel  context.CancelFunc
+	done    chan struct{}
+	elapsed time.Duration
+	joined  bool
+}
+
+// Start fn in the background with a deadline under ctx
+func startBackgroundPhase(ctx context.Context, name string, limit time.Duration, fn func(ctx context.Context)) *backgroundPhase {
+	ctx, cancel := context.WithTimeout(ctx, limit)
+	b := &backgroundPhase{name: name, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(b.done)
+		start := time.Now()
+		fn(ctx)
+		b.elapsed = time.Since(start)
+	}()
+	return b
+}
+
+// Wait for the phase to finish and time it in result. Only the first call does anything.
+func (b *backgroundPhase) wait(result *runResult) {
+	if b.joined {
+		return
+	}
+	b.joined = true
+	<-b.done
+	b.cancel()
+	result.Timings[b.name] = b.elapsed.Milliseconds()
+}
+
+// Cancel the phase if it is still running, timing it in result only if it had finished
+func (b *backgroundPhase) stop(result *runResult) {
+	if b.joined {
+		return
+	}
+	select {
+	case <-b.done:
+		b.wait(result)
+	default:
+		b.joined = true
+		b.cancel()
+		<-b.done
+	}
+}
+
+// Check whether a run stopped because it was cancelled or ran out of time
+func wasCancelled(err error) bool {
+	code := exitCode(err)
+	return code == exitInterrupted || code == exitTimeout
+}
+
+// State of the repository before gitdone touched it
+type repoSnapshot struct {
+	Head  string // Empty in a repository without commits
+	Index string // Tree object holding the staged state, empty if the index had conflicts
+}
+
+// Record HEAD and the index so a cancelled run can restore them
+func takeSnapshot(ctx context.Context) (*repoSnapshot, error) {
+	snapshot := &repoSnapshot{}
+	var index string
+	var err error
+	concurrently(
+		func() error {
+			if head, err := gitClient.Run(ctx, "rev-parse", "--verify", "-q", "HEAD"); err == nil {
+				snapshot.Head = strings.TrimSpace(head)
+			}
+			return nil
+		},
+		func() error {
+			index, err = gitClient.Run(ctx, "write-tree")
+			return nil
+		},
+	)
+	if err != nil {
+		// An index with conflicts can't be saved as a tree, so it won't be restored
+		if paths, pathsErr := unmergedPaths(ctx); pathsErr == nil && len(paths) > 0 {
+			return snapshot, nil
+		}
+		return nil, fmt.Errorf("Error saving the index: %v", err)
+	}
+	snapshot.Index = strings.TrimSpace(index)
+	return snapshot, nil
+}
+
+// Put the index back after a cancelled run and report what had already happened.
+// A commit that was already made is kept, since the changes are safe in it.
+func rollbackCancelledRun(snapshot *repoSnapshot, result *runResult) {
+	// The run's context is done, so clean up under a fresh one
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	if len(result.Steps) == 0 {
+		warn("Cancelled before any changes were made.\n")
+	} else {
+		warn("Cancelled after: %s\n", strings.Join(result.Steps, ", "))
+	}
+	restoreSnapshot(ctx, snapshot, result)
+}
+
+// Put the index back after a hook or the review stopped the run, so it
+// leaves nothing staged that the user had not staged themselves
+func rollbackVetoedRun(snapshot *repoSnapshot, result *runResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	restoreSnapshot(ctx, snapshot, result)
+}
+
+// Restore the index from snapshot unless a commit has landed since
+func restoreSnapshot(ctx context.Context, snapshot *repoSnapshot, result *runResult) {
+	head, _ := gitClient.Run(ctx, "rev-parse", "--verify", "-q", "HEAD")
+	if head = strings.TrimSpace(head); head != snapshot.Head {
+		// The commit may have landed even if git was killed before reporting it
+		if result.Commit == "" {
+			result.Commit = head
+			result.Steps = append(result.Steps, "committed "+shortSHA(head))
+		}
+		warn("Kept commit %s; it has not been pushed.\n", shortSHA(head))
+		return
+	}
+
+	if snapshot.Index == "" {
+		warn("The index had conflicts before gitdone ran, so it was left as it is.\n")
+		return
+	}
+	if _, err := gitClient.Run(ctx, "read-tree", snapshot.Index); err != nil {
+		errorLog("Could not restore the index: %v\n", err)
+		return
+	}
+	result.Steps = append(result.Steps, "restored index")
+	info("Restored the index to its state before gitdone ran.\n")
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A gitdone subcommand
+type command struct {
+	Name        string
+	Description string
+	Action      func(args []string) int
+}
+
+func getCommands() map[string]command {
+	return map[string]command{
+		"amend": {
+			Name:        "amend",
+			Description: "Regenerate the message of HEAD from its diff",
+			Action:      amendCommand,
+		},
+		"eval": {
+			Name:        "eval",
+			Description: "Score generated messages against a corpus of saved diffs",
+			Action:      evalCommand,
+		},
+		"explain": {
+			Name:        "explain",
+			Description: "Describe a commit or range in plain language",
+			Action:      explainCommand,
+		},
+		"push": {
+			Name:        "push",
+			Description: "Push commits queued while the remote was unreachable",
+			Action:      pushCommand,
+		},
+		"reword": {
+			Name:        "reword",
+			Description: "Regenerate the messages of unpushed commits in a range",
+			Action:      rewordCommand,
+		},
+		"review": {
+			Name:        "review",
+			Description: "Review the staged changes for obvious problems",
+			Action:      reviewCommand,
+		},
+		"stats": {
+			Name:        "stats",
+			Description: "Report how often generated messages were accepted or edited",
+			Action:      statsCommand,
+		},
+		"status": {
+			Name:        "status",
+			Description: "Show branches with commits that are not pushed yet",
+			Action:      statusCommand,
+		},
+		"trust": {
+			Name:        "trust",
+			Description: "Let the hooks in .gitdone.json run in this clone",
+			Action:      trustCommand,
+		},
+		"undo": {
+			Name:        "undo",
+			Description: "Undo the last gitdone commit, or revert it if it was pushed",
+			Action:      undoCommand,
+		},
+	}
+}
+
+func printUsage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n       %s <command> [flags]\n\nCommands:\n", name, name)
+
+	commands := getCommands()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].Description)
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// Check that we are in a repository and load its config
+func openRepo(ctx context.Context) (*config, error) {
+	if _, err := gitClient.Run(ctx, "rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("Not in a git repository")
+	}
+	return loadConfig(ctx)
+}
+
+// Register the signing flags shared by commands that create commits
+func signingFlags(fs *flag.FlagSet, opts *commitOptions) {
+	fs.BoolVar(&opts.Sign, "S", false, "Sign the commit (GPG or SSH, per gpg.format)")
+	fs.BoolVar(&opts.NoSign, "no-sign", false, "Do not sign the commit even if commit.gpgsign is set")
+}
+
+func checkSigningFlags(opts commitOptions) error {
+	if opts.Sign && opts.NoSign {
+		return fmt.Errorf("-S and --no-sign cannot be used together")
+	}
+	return nil
+}
+
+func amendCommand(args []string) int {
+	var opts commitOptions
+	fs := flag.NewFlagSet("amend", flag.ContinueOnError)
+	force := fs.Bool("force", false, "Rewrite HEAD even if it is already pushed")
+	signingFlags(fs, &opts)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		errorLog("Usage: gitdone amend [--force] [-S|--no-sign]\n")
+		return exitUsage
+	}
+	if err := checkSigningFlags(opts); err != nil {
+		errorLog("%v\n", err)
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	cfg, err := openRepo(ctx)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitNotRepo
+	}
+	if _, err := amendHead(ctx, cfg, opts, *force); err != nil {
+		errorLog("Error: %v\n", err)
+		return exitCommit
+	}
+	return exitOK
+}
+
+func rewordCommand(args []string) int {
+	var opts commitOptions
+	fs := flag.NewFlagSet("reword", flag.ContinueOnError)
+	force := fs.Bool("force", false, "Rewrite commits even if they are already pushed")
+	signingFlags(fs, &opts)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		errorLog("Usage: gitdone reword [--force] [-S|--no-sign] <range>\n")
+		return exitUsage
+	}
+	if err := checkSigningFlags(opts); err != nil {
+		errorLog("%v\n", err)
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	cfg, err := openRepo(ctx)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitNotRepo
+	}
+	count, err := rewordRange(ctx, fs.Arg(0), cfg, opts, *force)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitCommit
+	}
+	success("Reworded %d commit(s).\n", count)
+	return exitOK
+}
+
+func undoCommand(args []string) int {
+	fs := flag.NewFlagSet("undo", flag.ContinueOnError)
+	fs.BoolVar(&assumeYes, "yes", false, "Create a revert commit without asking if the commit was pushed")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		errorLog("Usage: gitdone undo [--yes]\n")
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if _, err := openRepo(ctx); err != nil {
+		errorLog("Error: %v\n", err)
+		return exitNotRepo
+	}
+	if err := undoLastRun(ctx); err != nil {
+		errorLog("Error: %v\n", err)
+		return exitCommit
+	}
+	return exitOK
+}
+
+func pushCommand(args []string) int {
+	fs := flag.NewFlagSet("push", flag.ContinueOnError)
+	pending := fs.Bool("pending", false, "Push every queued branch")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 || !*pending {
+		errorLog("Usage: gitdone push --pending\n")
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if _, err := openRepo(ctx); err != nil {
+		errorLog("Error: %v\n", err)
+		return exitNotRepo
+	}
+	pushed, err := flushPushQueue(ctx)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitPush
+	}
+	if pushed == 0 {
+		info("No queued pushes.\n")
+	}
+	return exitOK
+}
+
+func statusCommand(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		errorLog("Usage: gitdone status\n")
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if _, err := openRepo(ctx); err != nil {
+		errorLog("Error: %v\n", err)
+		return exitNotRepo
+	}
+	if err := printPushStatus(ctx); err != nil {
+		errorLog("Error: %v\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func explainCommand(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		errorLog("Usage: gitdone explain <commit|range>\n")
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	cfg, err := openRepo(ctx)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitNotRepo
+	}
+	explanation, err := explain(ctx, fs.Arg(0), cfg)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitGenerate
+	}
+	fmt.Fprintln(os.Stdout, explanation)
+	if messageMismatch(explanation) {
+		warn("The commit message does not match what the diff does.\n")
+	}
+	return exitOK
+}
+
+func reviewCommand(args []string) int {
+	fs := flag.NewFlagSet("review", flag.ContinueOnError)
+	block := fs.Bool("block", false, "Exit with code 12 if there are high-severity findings")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		errorLog("Usage: gitdone review [--block]\n")
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if _, err := openRepo(ctx); err != nil {
+		errorLog("Error: %v\n", err)
+		return exitNotRepo
+	}
+	staged, err := gitClient.Run(ctx, "diff", "--cached", "--name-only")
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitError
+	}
+	if strings.TrimSpace(staged) == "" {
+		info("Nothing is staged to review.\n")
+		return exitNoChanges
+	}
+	findings, err := reviewStagedDiff(ctx)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitGenerate
+	}
+	printFindings(os.Stdout, findings)
+	if *block && highSeverity(findings) > 0 {
+		return exitReview
+	}
+	return exitOK
+}
+
+func evalCommand(args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	record := fs.Bool("record", false, "Use the live model and record its responses in the corpus")
+	stub := fs.Bool("stub", false, "Use a stub model that names the changed files, to check the pipeline")
+	baseline := fs.String("baseline", "", "Results saved by an earlier run to compare against")
+	save := fs.String("save", "", "Save the results to this file")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 || (*record && *stub) {
+		errorLog("Usage: gitdone eval [--record|--stub] [--baseline results.json] [--save results.json] <corpus-dir>\n")
+		return exitUsage
+	}
+	dir := fs.Arg(0)
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	cases, err := loadEvalCorpus(dir)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitError
+	}
+	var before *evalReport
+	if *baseline != "" {
+		if before, err = loadEvalReport(*baseline); err != nil {
+			errorLog("Error: %v\n", err)
+			return exitError
+		}
+	}
+	responses, err := loadRecordings(dir)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitError
+	}
+
+	recorded := &recordedModel{Stub: *stub, Responses: responses}
+	if *record {
+		recorded.Live = model
+	}
+	previous := model
+	model = recorded
+	report, err := runEval(ctx, dir, cases)
+	model = previous
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitError
+	}
+
+	if *record {
+		if err := saveRecordings(dir, recorded.Responses); err != nil {
+			errorLog("Error: %v\n", err)
+			return exitError
+		}
+	}
+	if *save != "" {
+		if err := saveEvalReport(*save, report); err != nil {
+			errorLog("Error: %v\n", err)
+			return exitError
+		}
+	}
+	printEvalTable(os.Stdout, report, before)
+	for _, result := range report.Results {
+		if result.Error != "" {
+			return exitGenerate
+		}
+	}
+	return exitOK
+}
+
+func statsCommand(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+		errorLog("Usage: gitdone stats [range]\n")
+		return exitUsage
+	}
+	revRange := "HEAD"
+	if fs.NArg() == 1 {
+		revRange = fs.Arg(0)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if _, err := openRepo(ctx); err != nil {
+		errorLog("Error: %v\n", err)
+		return exitNotRepo
+	}
+	stats, err := readProvenanceStats(ctx, revRange)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitError
+	}
+	printProvenanceStats(os.Stdout, stats)
+	return exitOK
+}
+
+func trustCommand(args []string) int {
+	fs := flag.NewFlagSet("trust", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		errorLog("Usage: gitdone trust\n")
+		return exitUsage
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	cfg, err := openRepo(ctx)
+	if err != nil {
+		errorLog("Error: %v\n", err)
+		return exitNotRepo
+	}
+	if len(cfg.Hooks) == 0 {
+		info("No hooks are configured in %s.\n", configFileName)
+		return exitOK
+	}
+	if err := trustHooks(ctx, cfg); err != nil {
+		errorLog("Error: %v\n", err)
+		return exitError
+	}
+	for _, point := range hookPoints {
+		for _, script := range cfg.Hooks[point] {
+			info("  %-14s %s\n", point, script)
+		}
+	}
+	success("Trusted these hooks; changing any of them needs gitdone trust again.\n")
+	return exitOK
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const configFileName = ".gitdone.json"
+
+// Repository settings read from .gitdone.json at the repository root
+type config struct {
+	// Regular expressions matched against the branch name to find ticket keys
+	TicketPatterns []string `json:"ticketPatterns"`
+	// Where ticket keys go: "subject" prefixes the subject, "trailer" adds a trailer
+	TicketPlacement string `json:"ticketPlacement"`
+	// Trailer key used when TicketPlacement is "trailer"
+	TicketTrailer string `json:"ticketTrailer"`
+	// Warn when the branch name has no ticket key
+	RequireTicket bool `json:"requireTicket"`
+	// Pair partners by alias, as "Name <email>", for Co-authored-by trailers
+	Pairs map[string]string `json:"pairs"`
+	// Module roots in addition to directories containing go.mod or package.json
+	ModuleRoots []string `json:"moduleRoots"`
+	// Most modules named in a subject before gitdone suggests splitting the commit
+	MaxScopes int `json:"maxScopes"`
+	// Size in bytes above which a staged file needs confirmation, unless LFS tracks it
+	MaxFileSize int64 `json:"maxFileSize"`
+	// Lower size limit for binary files
+	MaxBinarySize int64 `json:"maxBinarySize"`
+	// Executables to run at each hook point: pre-summary, post-generate, pre-commit, post-push
+	Hooks map[string][]string `json:"hooks"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		TicketPatterns:  []string{`[A-Z][A-Z0-9]+-[0-9]+`},
+		TicketPlacement: "trailer",
+		TicketTrailer:   "Refs",
+	}
+}
+
+// Get the top-level directory of the current repository
+func repoRoot(ctx context.Context) (string, error) {
+	root, err := gitClient.Run(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", fmt.Errorf("Error locating repository root: %v", err)
+	}
+	return strings.TrimSpace(root), nil
+}
+
+// Load the repository config, falling back to defaults for anything unset
+func loadConfig(ctx context.Context) (*config, error) {
+	cfg := defaultConfig()
+
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(root, configFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("Error reading %s: %v", configFileName, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", configFileName, err)
+	}
+
+	switch cfg.TicketPlacement {
+	case "subject", "trailer":
+	default:
+		return nil, fmt.Errorf("Invalid ticketPlacement %q in %s, expected \"subject\" or \"trailer\"", cfg.TicketPlacement, configFileName)
+	}
+	if cfg.TicketTrailer == "" {
+		cfg.TicketTrailer = "Refs"
+	}
+	if err := validateHooks(cfg.Hooks); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A dependency added, removed or moved to another version in a manifest
+type dependencyChange struct {
+	Name string
+	From string // Empty if the dependency was added
+	To   string // Empty if the dependency was removed
+}
+
+func (c dependencyChange) isBump() bool {
+	return c.From != "" && c.To != ""
+}
+
+func (c dependencyChange) String() string {
+	switch {
+	case c.From == "":
+		return strings.TrimSpace("Added dependency " + c.Name + " " + c.To)
+	case c.To == "":
+		return "Removed dependency " + c.Name
+	}
+	return fmt.Sprintf("Bumped %s %s → %s", c.Name, c.From, c.To)
+}
+
+// The dependencies of a manifest, and everything else in it so changes
+// outside the dependency list can be told apart
+type manifest struct {
+	Deps  map[string]string
+	Other string
+}
+
+// Parsers for the manifests gitdone understands, by base name
+var manifestParsers = map[string]func(string) (*manifest, error){
+	"go.mod":           parseGoMod,
+	"package.json":     parsePackageJSON,
+	"requirements.txt": parseRequirements,
+}
+
+// Parse the require directives of a go.mod file
+func parseGoMod(content string) (*manifest, error) {
+	m := &manifest{Deps: make(map[string]string)}
+	var other strings.Builder
+	inRequire := false
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		switch {
+		case inRequire && len(fields) > 0 && fields[0] == ")":
+			inRequire = false
+			continue
+		case inRequire && len(fields) >= 2:
+			m.Deps[fields[0]] = fields[1]
+			continue
+		case len(fields) == 2 && fields[0] == "require" && fields[1] == "(":
+			inRequire = true
+			continue
+		case len(fields) >= 3 && fields[0] == "require":
+			m.Deps[fields[1]] = fields[2]
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			other.WriteString(strings.TrimSpace(line))
+			other.WriteByte('\n')
+		}
+	}
+	m.Other = other.String()
+	return m, scanner.Err()
+}
+
+// Sections of package.json that list dependencies
+var packageJSONSections = []string{"dependencies", "devDependencies", "peerDependencies", "optionalDependencies"}
+
+// Parse the dependency sections of a package.json file
+func parsePackageJSON(content string) (*manifest, error) {
+	m := &manifest{Deps: make(map[string]string)}
+	if strings.TrimSpace(content) == "" {
+		return m, nil
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &doc); err != nil {
+		return nil, fmt.Errorf("Error parsing package.json: %v", err)
+	}
+	for _, section := range packageJSONSections {
+		raw, ok := doc[section]
+		if !ok {
+			continue
+		}
+		var deps map[string]string
+		if err := json.Unmarshal(raw, &deps); err != nil {
+			return nil, fmt.Errorf("Error parsing %s in package.json: %v", section, err)
+		}
+		for name, version := range deps {
+			m.Deps[name] = version
+		}
+		delete(doc, section)
+	}
+
+	// Map keys marshal sorted, so formatting changes don't count as other changes
+	other, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	m.Other = string(other)
+	return m, nil
+}
+
+// A requirement line: a name, optional extras, then an optional version specifier
+var requirementLine = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)(\[[^\]]*\])?\s*(.*)$`)
+
+// Parse a pip requirements file
+func parseRequirements(content string) (*manifest, error) {
+	m := &manifest{Deps: make(map[string]string)}
+	var other strings.Builder
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		match := requirementLine.FindStringSubmatch(line)
+		if match == nil || strings.HasPrefix(line, "-") {
+			// Options such as -r and --index-url
+			other.WriteString(line)
+			other.WriteByte('\n')
+			continue
+		}
+		// pip treats names case-insensitively and "_" like "-"
+		name := strings.ReplaceAll(strings.ToLower(match[1]), "_", "-")
+		version := strings.TrimSpace(match[3])
+		version = strings.TrimPrefix(version, "==")
+		m.Deps[name] = strings.TrimSpace(version)
+	}
+	m.Other = other.String()
+	return m, scanner.Err()
+}
+
+// Compare two sets of dependencies, sorted by name
+func diffDependencies(before, after map[string]string) []dependencyChange {
+	var changes []dependencyChange
+	for name, from := range before {
+		if to, ok := after[name]; !ok {
+			changes = append(changes, dependencyChange{Name: name, From: from})
+		} else if to != from {
+			changes = append(changes, dependencyChange{Name: name, From: from, To: to})
+		}
+	}
+	for name, to := range after {
+		if _, ok := before[name]; !ok {
+			changes = append(changes, dependencyChange{Name: name, To: to})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// The two sides of a diff: revisions to read files from, where an empty
+// After means the index
+type diffSource struct {
+	Before string
+	After  string
+}
+
+// The staged changes that a normal run commits
+var stagedChanges = diffSource{Before: "HEAD"}
+
+// The changes made by a commit
+func commitChanges(sha string) diffSource {
+	return diffSource{Before: sha + "^", After: sha}
+}
+
+// Arguments that make git diff compare the two sides
+func (s diffSource) diffArgs() []string {
+	switch {
+	case s.After != "":
+		return []string{s.Before, s.After}
+	case s.Before == "HEAD":
+		// Unlike naming HEAD, this also works before the first commit
+		return []string{"--cached"}
+	}
+	return []string{"--cached", s.Before}
+}
+
+// Read a file at a revision, or from the index if rev is empty.
+// A file that doesn't exist there reads as "".
+func readRevisionFile(ctx context.Context, rev, file string) string {
+	content, err := gitClient.Run(ctx, "show", rev+":"+file)
+	if err != nil {
+		return ""
+	}
+	return content
+}
+
+// Compare both sides of each changed manifest and put the exact dependency
+// changes at the top of its summary
+func markDependencyChanges(ctx context.Context, files []*fileChanges, source diffSource) error {
+	// Read both sides of every manifest at once
+	oldContent, newContent := make([]string, len(files)), make([]string, len(files))
+	var reads []func() error
+	for i, file := range files {
+		if _, ok := manifestParsers[path.Base(file.Path)]; !ok || file.Generated != "" {
+			continue
+		}
+		i, file := i, file
+		reads = append(reads,
+			func() error { oldContent[i] = readRevisionFile(ctx, source.Before, file.Path); return nil },
+			func() error { newContent[i] = readRevisionFile(ctx, source.After, file.Path); return nil },
+		)
+	}
+	concurrently(reads...)
+
+	for i, file := range files {
+		parse, ok := manifestParsers[path.Base(file.Path)]
+		if !ok || file.Generated != "" {
+			continue
+		}
+
+		before, err := parse(oldContent[i])
+		if err != nil {
+			warn("Skipping dependency changes in %s: %v\n", file.Path, err)
+			continue
+		}
+		after, err := parse(newContent[i])
+		if err != nil {
+			warn("Skipping dependency changes in %s: %v\n", file.Path, err)
+			continue
+		}
+
+		file.Dependencies = diffDependencies(before.Deps, after.Deps)
+		file.OnlyDependencies = before.Other == after.Other
+		if len(file.Dependencies) == 0 {
+			continue
+		}
+		changes := make([]string, 0, len(file.Dependencies)+len(file.Changes))
+		for _, change := range file.Dependencies {
+			changes = append(changes, change.String())
+		}
+		file.Changes = append(changes, file.Changes...)
+	}
+	return ctx.Err()
+}
+
+// Write a commit message for a change that only bumps dependency versions,
+// or return false if the change does anything else
+func dependencyBumpMessage(modules []*moduleChanges) (string, bool) {
+	var bumps []dependencyChange
+	var manifests []string
+	for _, module := range modules {
+		for _, file := range module.Files {
+			switch {
+			case file.Generated == "lock file":
+				continue
+			case len(file.Dependencies) == 0 || !file.OnlyDependencies:
+				return "", false
+			}
+			for _, change := range file.Dependencies {
+				if !change.isBump() {
+					return "", false
+				}
+			}
+			bumps = append(bumps, file.Dependencies...)
+			manifests = append(manifests, file.Path)
+		}
+	}
+	if len(bumps) == 0 {
+		return "", false
+	}
+
+	// Keep to a single subject line, dropping detail until it fits
+	var candidates []string
+	if len(bumps) == 1 {
+		bump := bumps[0]
+		candidates = append(candidates,
+			fmt.Sprintf("Bumped %s from %s to %s", bump.Name, bump.From, bump.To),
+			fmt.Sprintf("Bumped %s to %s", bump.Name, bump.To),
+			"Bumped "+bump.Name)
+	} else {
+		names := make([]string, 0, len(bumps))
+		for _, bump := range bumps {
+			names = append(names, bump.Name)
+		}
+		candidates = append(candidates, "Bumped "+strings.Join(names, ", "))
+		if len(manifests) == 1 {
+			candidates = append(candidates, fmt.Sprintf("Bumped %d dependencies in %s", len(bumps), manifests[0]))
+		}
+	}
+	for _, msg := range candidates {
+		if len(msg) <= 72 {
+			return msg, true
+		}
+	}
+	return fmt.Sprintf("Bumped %d dependencies", len(bumps)), true
+}
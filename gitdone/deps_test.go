@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const goModBefore = `module example.com/app
+
+go 1.21
+
+require (
+	github.com/fatih/color v1.16.0
+	github.com/mattn/go-isatty v0.0.20 // indirect
+)
+
+require golang.org/x/sys v0.14.0 // indirect
+`
+
+func TestParseManifests(t *testing.T) {
+	gomod, err := parseGoMod(goModBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"github.com/fatih/color":     "v1.16.0",
+		"github.com/mattn/go-isatty": "v0.0.20",
+		"golang.org/x/sys":           "v0.14.0",
+	}
+	if !reflect.DeepEqual(gomod.Deps, want) {
+		t.Errorf("go.mod deps = %v, want %v", gomod.Deps, want)
+	}
+
+	pkg, err := parsePackageJSON(`{"name": "app", "dependencies": {"react": "^18.2.0"}, "devDependencies": {"vite": "5.0.0"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"react": "^18.2.0", "vite": "5.0.0"}; !reflect.DeepEqual(pkg.Deps, want) {
+		t.Errorf("package.json deps = %v, want %v", pkg.Deps, want)
+	}
+
+	reqs, err := parseRequirements("# pinned\nRequests==2.31.0\nuvicorn[standard]>=0.23  # server\n-r base.txt\nflask\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"requests": "2.31.0", "uvicorn": ">=0.23", "flask": ""}; !reflect.DeepEqual(reqs.Deps, want) {
+		t.Errorf("requirements deps = %v, want %v", reqs.Deps, want)
+	}
+	if reqs.Other != "-r base.txt\n" {
+		t.Errorf("requirements other = %q", reqs.Other)
+	}
+}
+
+func TestSummaryListsDependencyChanges(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("go.mod", goModBefore)
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added go.mod")
+
+	after := strings.NewReplacer(
+		"github.com/fatih/color v1.16.0", "github.com/fatih/color v1.17.0",
+		"require golang.org/x/sys v0.14.0 // indirect\n", "require github.com/spf13/cobra v1.8.0\n",
+	).Replace(goModBefore)
+	repo.write("go.mod", after)
+	repo.git("add", ".")
+
+	files, err := readStagedChanges(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, summary, err := summarizeFiles(context.Background(), files, stagedChanges, repo.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Bumped github.com/fatih/color v1.16.0 → v1.17.0",
+		"Added dependency github.com/spf13/cobra v1.8.0",
+		"Removed dependency golang.org/x/sys",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary missing %q:\n%s", want, summary)
+		}
+	}
+}
+
+func TestDependencyBumpSkipsModel(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Updated stuff")
+	repo.write("go.mod", goModBefore)
+	repo.write("go.sum", "github.com/fatih/color v1.16.0 h1:old=\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added go.mod")
+
+	repo.write("go.mod", strings.Replace(goModBefore, "color v1.16.0", "color v1.17.0", 1))
+	repo.write("go.sum", "github.com/fatih/color v1.17.0 h1:new=\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	if got := repo.git("log", "-1", "--format=%s"); got != "Bumped github.com/fatih/color from v1.16.0 to v1.17.0" {
+		t.Errorf("commit subject = %q", got)
+	}
+	if prompts := server.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("model was called for a dependency bump: %q", prompts)
+	}
+}
+
+func TestDependencyChangeWithOtherEditsUsesModel(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Upgraded color and Go version")
+	repo.write("go.mod", goModBefore)
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added go.mod")
+
+	bumped := strings.Replace(goModBefore, "color v1.16.0", "color v1.17.0", 1)
+	repo.write("go.mod", strings.Replace(bumped, "go 1.21", "go 1.22", 1))
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	if prompts := server.receivedPrompts(); len(prompts) != 1 {
+		t.Errorf("model prompts = %d, want 1", len(prompts))
+	}
+}
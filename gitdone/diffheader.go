@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Git's mode for a submodule entry
+const submoduleMode = "160000"
+
+// What the extended header lines of one file in a diff say about it
+type diffHeader struct {
+	OldMode, NewMode string
+	RenameFrom       string
+	CopyFrom         string
+	NewFile          bool
+	Deleted          bool
+	Submodule        bool
+	OldCommit        string // Submodule commit before the change
+	NewCommit        string // Submodule commit after the change
+	Hunks            bool
+}
+
+// Record a header line of a file's diff, returning false for any other line.
+// Submodule pointers appear as "Subproject commit" lines inside the hunk.
+func (h *diffHeader) parse(line string) bool {
+	value := func(prefix string) (string, bool) {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix), true
+		}
+		return "", false
+	}
+
+	if v, ok := value("old mode "); ok {
+		h.OldMode = v
+	} else if v, ok := value("new mode "); ok {
+		h.NewMode = v
+	} else if v, ok := value("new file mode "); ok {
+		h.NewFile = true
+		h.Submodule = v == submoduleMode
+	} else if v, ok := value("deleted file mode "); ok {
+		h.Deleted = true
+		h.Submodule = v == submoduleMode
+	} else if v, ok := value("rename from "); ok {
+		h.RenameFrom = v
+	} else if v, ok := value("copy from "); ok {
+		h.CopyFrom = v
+	} else if v, ok := value("index "); ok {
+		// "index abc..def 160000" for a submodule whose pointer moved
+		h.Submodule = h.Submodule || strings.HasSuffix(v, " "+submoduleMode)
+	} else if v, ok := value("-Subproject commit "); ok && h.Submodule {
+		h.OldCommit = v
+	} else if v, ok := value("+Subproject commit "); ok && h.Submodule {
+		h.NewCommit = v
+	} else if strings.HasPrefix(line, "rename to ") || strings.HasPrefix(line, "copy to ") ||
+		strings.HasPrefix(line, "similarity index ") || strings.HasPrefix(line, "dissimilarity index ") {
+		// The new path comes from the diff --git line
+	} else {
+		return false
+	}
+	return true
+}
+
+// Describe the whole-file operations on path, such as renames and deletions
+func (h *diffHeader) operations(path string) []string {
+	if h.Submodule {
+		switch {
+		case h.NewFile:
+			return []string{fmt.Sprintf("Added submodule %s at %s", path, shortSHA(h.NewCommit))}
+		case h.Deleted:
+			return []string{"Removed submodule " + path}
+		}
+		return []string{fmt.Sprintf("Updated submodule %s from %s to %s", path, shortSHA(h.OldCommit), shortSHA(h.NewCommit))}
+	}
+
+	var ops []string
+	switch {
+	case h.RenameFrom != "":
+		ops = append(ops, fmt.Sprintf("Renamed %s to %s", h.RenameFrom, path))
+	case h.CopyFrom != "":
+		ops = append(ops, fmt.Sprintf("Copied %s to %s", h.CopyFrom, path))
+	case h.NewFile && !h.Hunks:
+		ops = append(ops, "Created empty file "+path)
+	case h.NewFile:
+		ops = append(ops, "Created "+path)
+	case h.Deleted:
+		ops = append(ops, "Deleted "+path)
+	}
+	if h.OldMode != "" && h.NewMode != "" {
+		ops = append(ops, describeModeChange(path, h.OldMode, h.NewMode))
+	}
+	return ops
+}
+
+func describeModeChange(path, oldMode, newMode string) string {
+	switch {
+	case oldMode == "100644" && newMode == "100755":
+		return "Made " + path + " executable"
+	case oldMode == "100755" && newMode == "100644":
+		return "Made " + path + " non-executable"
+	case newMode == "120000":
+		return "Replaced " + path + " with a symlink"
+	case oldMode == "120000":
+		return "Replaced symlink " + path + " with a file"
+	}
+	return fmt.Sprintf("Changed mode of %s from %s to %s", path, oldMode, newMode)
+}
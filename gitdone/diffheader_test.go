@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSummaryDescribesFileOperations(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write("util.go", "package main\n\nfunc helper() int {\n\treturn 1\n}\n")
+	repo.write("old.go", "package main\n\nfunc legacy() {}\n")
+	repo.write("run.sh", "#!/bin/sh\necho hi\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added helpers")
+	first := repo.git("rev-parse", "HEAD~1")
+	second := repo.git("rev-parse", "HEAD")
+	repo.git("update-index", "--add", "--cacheinfo", "160000,"+first+",lib")
+	repo.git("commit", "-m", "Added lib submodule")
+
+	repo.git("mv", "util.go", "helpers.go")
+	repo.git("rm", "-q", "old.go")
+	repo.git("update-index", "--chmod=+x", "run.sh")
+	repo.write("empty.txt", "")
+	repo.git("add", "empty.txt")
+	repo.git("update-index", "--cacheinfo", "160000,"+second+",lib")
+
+	files, err := readStagedChanges(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, summary, err := summarizeFiles(context.Background(), files, stagedChanges, repo.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"* helpers.go",
+		"Renamed util.go to helpers.go",
+		"Deleted old.go",
+		"Made run.sh executable",
+		"Created empty file empty.txt",
+		"Updated submodule lib from " + shortSHA(first) + " to " + shortSHA(second),
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary missing %q:\n%s", want, summary)
+		}
+	}
+	if strings.Contains(summary, "func legacy") {
+		t.Errorf("summary includes content of a deleted file:\n%s", summary)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStagesCommitsAndPushes(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added greeting helper in hello.go")
+
+	repo.write("hello.go", "package main\n\nfunc hello() string {\n\treturn \"hi\"\n}\n")
+	repo.write("main.go", "package main\n\nfunc main() {\n\tprintln(hello())\n}\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	if got := repo.git("status", "--porcelain"); got != "" {
+		t.Errorf("working tree not clean after run:\n%s", got)
+	}
+	if got := repo.git("log", "-1", "--format=%s"); got != "Added greeting helper in hello.go" {
+		t.Errorf("commit subject = %q", got)
+	}
+	if got := repo.git("diff-tree", "--no-commit-id", "--name-only", "-r", "HEAD"); got != "hello.go\nmain.go" {
+		t.Errorf("committed files = %q", got)
+	}
+	if !result.Pushed || result.Branch != "main" {
+		t.Errorf("result pushed=%v branch=%q", result.Pushed, result.Branch)
+	}
+	if remote := strings.TrimSpace(runGitIn(t, repo.Remote, "rev-parse", "main")); remote != result.Commit {
+		t.Errorf("remote main = %s, want %s", remote, result.Commit)
+	}
+}
+
+func TestRunSummarizesStagedChanges(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added retry loop to fetch")
+
+	repo.write("fetch.go", "package main\n\nfunc fetch() error {\n\tif err := try(); err != nil {\n\t\treturn err\n\t}\n\treturn nil\n}\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	for _, want := range []string{"* fetch.go", "Added: func fetch() error {", "Added: \tif err := try(); err != nil {"} {
+		if !strings.Contains(result.Summary, want) {
+			t.Errorf("summary missing %q:\n%s", want, result.Summary)
+		}
+	}
+
+	prompts := server.receivedPrompts()
+	if len(prompts) != 1 || !strings.Contains(prompts[0], result.Summary) {
+		t.Errorf("model did not receive the summary, prompts: %q", prompts)
+	}
+}
+
+func TestRunAddsTicketAndTrailers(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Fixed timeout handling")
+
+	repo.git("checkout", "-b", "PROJ-1234-fix-timeout")
+	repo.write("timeout.go", "package main\n\nconst limit = 5\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{Signoff: true}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	want := "Fixed timeout handling\n\nRefs: PROJ-1234\nSigned-off-by: Test User <test@example.com>"
+	if got := repo.git("log", "-1", "--format=%B"); got != want {
+		t.Errorf("commit message = %q, want %q", got, want)
+	}
+}
+
+func TestSubjectTicketKeepsBody(t *testing.T) {
+	cfg := &config{TicketPlacement: "subject"}
+	msg := "Fixed timeout handling\n\nThe limit was read before the config.\nIt is now read after."
+	want := "PROJ-12: Fixed timeout handling\n\nThe limit was read before the config.\nIt is now read after."
+	if got := applyTicketKeys(msg, []string{"PROJ-12"}, cfg); got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+	if got := applyTicketKeys("Fixed timeout handling", []string{"PROJ-12"}, cfg); got != "PROJ-12: Fixed timeout handling" {
+		t.Errorf("subject-only message = %q", got)
+	}
+}
+
+func TestRunWithNoChanges(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Updated nothing")
+
+	err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult())
+	if !errors.Is(err, errNoChanges) || exitCode(err) != exitNoChanges {
+		t.Fatalf("err = %v (exit %d), want no changes", err, exitCode(err))
+	}
+	if len(server.receivedPrompts()) != 0 {
+		t.Error("model was called with nothing to commit")
+	}
+}
+
+func TestRunPushFailure(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added config loader")
+
+	repo.git("remote", "set-url", "origin", repo.Remote+"-missing")
+	repo.write("config.go", "package main\n\nvar loaded bool\n")
+
+	result := newRunResult()
+	err := runGitdone(context.Background(), repo.config(), commitOptions{}, result)
+	if exitCode(err) != exitPush {
+		t.Fatalf("err = %v (exit %d), want push failure", err, exitCode(err))
+	}
+	if result.Pushed || result.PushError == "" {
+		t.Errorf("result pushed=%v pushError=%q", result.Pushed, result.PushError)
+	}
+	if head := repo.git("rev-parse", "HEAD"); result.Commit != head {
+		t.Errorf("result commit = %q, want local HEAD %q", result.Commit, head)
+	}
+}
+
+func TestRunModelErrorStatus(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "unused")
+	server.set(func(f *fakeModelServer) { f.status = http.StatusInternalServerError })
+
+	repo.write("broken.go", "package main\n\nvar broken = true\n")
+
+	err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult())
+	if exitCode(err) != exitGenerate {
+		t.Fatalf("err = %v (exit %d), want generation failure", err, exitCode(err))
+	}
+	if got := len(server.receivedPrompts()); got != maxRetries {
+		t.Errorf("model called %d times, want %d", got, maxRetries)
+	}
+	if got := repo.git("log", "-1", "--format=%s"); got != "Added main package" {
+		t.Errorf("a commit was made despite the failure: %q", got)
+	}
+}
+
+func TestRunModelTimeout(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added slow path")
+	server.set(func(f *fakeModelServer) { f.delay = time.Minute })
+	model.(*ollamaClient).Timeout = 50 * time.Millisecond
+
+	repo.write("slow.go", "package main\n\nfunc slow() {}\n")
+
+	err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult())
+	if exitCode(err) != exitGenerate {
+		t.Fatalf("err = %v (exit %d), want generation failure", err, exitCode(err))
+	}
+}
+
+func TestRunGenerationTimeoutRestoresIndex(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added slow path")
+	server.set(func(f *fakeModelServer) { f.delay = time.Minute })
+
+	previous := phaseTimeouts.Generate
+	phaseTimeouts.Generate = 200 * time.Millisecond
+	t.Cleanup(func() { phaseTimeouts.Generate = previous })
+
+	repo.write("staged.go", "package main\n")
+	repo.git("add", "staged.go")
+	repo.write("slow.go", "package main\n\nfunc slow() {}\n")
+
+	result := newRunResult()
+	err := runGitdone(context.Background(), repo.config(), commitOptions{}, result)
+	if exitCode(err) != exitTimeout {
+		t.Fatalf("err = %v (exit %d), want timeout", err, exitCode(err))
+	}
+	if got := repo.git("diff", "--cached", "--name-only"); got != "staged.go" {
+		t.Errorf("staged files after rollback = %q, want only staged.go", got)
+	}
+	if got := repo.git("log", "-1", "--format=%s"); got != "Added main package" {
+		t.Errorf("a commit was made despite the timeout: %q", got)
+	}
+	want := []string{"staged changes", "restored index"}
+	if strings.Join(result.Steps, ",") != strings.Join(want, ",") {
+		t.Errorf("steps = %q, want %q", result.Steps, want)
+	}
+}
+
+func TestRunInterruptedDuringGeneration(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added slow path")
+	server.set(func(f *fakeModelServer) { f.delay = time.Minute })
+
+	repo.write("slow.go", "package main\n\nfunc slow() {}\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for len(server.receivedPrompts()) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+
+	err := runGitdone(ctx, repo.config(), commitOptions{}, newRunResult())
+	if exitCode(err) != exitInterrupted {
+		t.Fatalf("err = %v (exit %d), want interrupted", err, exitCode(err))
+	}
+	if got := repo.git("status", "--porcelain"); got != "?? slow.go" {
+		t.Errorf("status after rollback = %q, want slow.go untracked", got)
+	}
+}
+
+func TestRunWarmsModelAlongsideGit(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added warm path")
+	repo.write("warm.go", "package main\n\nfunc warm() {}\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	if got := server.receivedWarmups(); got != 1 {
+		t.Errorf("got %d warm-up requests, want 1", got)
+	}
+	for _, phase := range []string{"warmup", "flush", "stage", "generate", "push"} {
+		if _, ok := result.Timings[phase]; !ok {
+			t.Errorf("no timing for %s in %v", phase, result.Timings)
+		}
+	}
+}
+
+func TestRunDoesNotWaitForWarmup(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added cold path")
+	server.set(func(f *fakeModelServer) { f.stalled = true })
+	repo.write("cold.go", "package main\n\nfunc cold() {}\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	if _, ok := result.Timings["warmup"]; ok {
+		t.Errorf("an unfinished warm-up was timed: %v", result.Timings)
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// File in the corpus directory holding the recorded model responses
+const recordingsFileName = "recordings.json"
+
+// A saved diff and the message a person wrote for it
+type evalCase struct {
+	Name      string
+	Diff      string
+	Reference string
+	Base      string // Directory holding the files the diff applies to, empty if it only adds files
+}
+
+// Scores for the message generated for one case
+type evalResult struct {
+	Case    string   `json:"case"`
+	Message string   `json:"message"`
+	Lint    []string `json:"lint"`    // Lint rules the message breaks
+	Overlap float64  `json:"overlap"` // Jaccard similarity of the words to the reference
+	LCS     float64  `json:"lcs"`     // F1 of the longest common word subsequence with the reference
+	Error   string   `json:"error,omitempty"`
+}
+
+// Results of an eval run, saved with --save to compare against later
+type evalReport struct {
+	Results []evalResult `json:"results"`
+}
+
+// Load the corpus: each name.diff with the reference message in name.msg,
+// and the files the diff changes, as they were before, under name.base/
+func loadEvalCorpus(dir string) ([]evalCase, error) {
+	diffs, err := filepath.Glob(filepath.Join(dir, "*.diff"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(diffs)
+
+	var cases []evalCase
+	for _, diffFile := range diffs {
+		name := strings.TrimSuffix(filepath.Base(diffFile), ".diff")
+		diff, err := os.ReadFile(diffFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading %s: %v", diffFile, err)
+		}
+		reference, err := os.ReadFile(filepath.Join(dir, name+".msg"))
+		if err != nil {
+			return nil, fmt.Errorf("Case %s has no reference message: %v", name, err)
+		}
+		c := evalCase{Name: name, Diff: string(diff), Reference: strings.TrimSpace(string(reference))}
+		if stat, err := os.Stat(filepath.Join(dir, name+".base")); err == nil && stat.IsDir() {
+			c.Base = filepath.Join(dir, name+".base")
+		}
+		cases = append(cases, c)
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("No *.diff files in %s", dir)
+	}
+	return cases, nil
+}
+
+// Model client that replays recorded responses, records live ones, or stubs them
+type recordedModel struct {
+	Live      modelClient       // Set when recording
+	Stub      bool              // Answer without a model at all
+	Responses map[string]string // By promptKey
+}
+
+// Key a recording by prompt, so changing the prompt needs a new recording
+func promptKey(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *recordedModel) Generate(ctx context.Context, prompt string) (string, error) {
+	key := promptKey(prompt)
+	switch {
+	case m.Stub:
+		return stubResponse(prompt), nil
+	case m.Live != nil:
+		response, err := m.Live.Generate(ctx, prompt)
+		if err != nil {
+			return "", err
+		}
+		m.Responses[key] = response
+		return response, nil
+	}
+	response, ok := m.Responses[key]
+	if !ok {
+		return "", fmt.Errorf("No recorded response for prompt %s; the prompt changed, so record again with --record", key[:12])
+	}
+	return response, nil
+}
+
+func (m *recordedModel) Embed(ctx context.Context, text string) ([]float64, error) {
+	// A zero vector matches nothing, so the scratch repository's base commit
+	// never shows up as an example in the prompt
+	return []float64{0}, nil
+}
+
+func (m *recordedModel) Warm(ctx context.Context) error {
+	if m.Live != nil {
+		return m.Live.Warm(ctx)
+	}
+	return nil
+}
+
+// File headings in a change summary, such as "In client.go (+3 -1):"
+var summaryFileHeading = regexp.MustCompile(`(?m)^In (\S+)`)
+
+// A deterministic message naming the files in the prompt, to exercise the pipeline without a model
+func stubResponse(prompt string) string {
+	var names []string
+	for _, m := range summaryFileHeading.FindAllStringSubmatch(prompt, -1) {
+		if name := filepath.Base(m[1]); !containsString(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "Updated code"
+	}
+	return "Updated " + strings.Join(names, " and ")
+}
+
+// Load recorded responses from the corpus, if there are any
+func loadRecordings(dir string) (map[string]string, error) {
+	responses := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(dir, recordingsFileName))
+	if os.IsNotExist(err) {
+		return responses, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading recordings: %v", err)
+	}
+	if err := json.Unmarshal(data, &responses); err != nil {
+		return nil, fmt.Errorf("Error parsing recordings: %v", err)
+	}
+	return responses, nil
+}
+
+func saveRecordings(dir string, responses map[string]string) error {
+	data, err := json.MarshalIndent(responses, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshaling recordings: %v", err)
+	}
+	return os.WriteFile(filepath.Join(dir, recordingsFileName), append(data, '\n'), 0644)
+}
+
+// Scopes as applyScopes writes them, such as "api, web: "
+var scopePrefix = regexp.MustCompile(`^[\w./-]+(, [\w./-]+)*: `)
+
+// Subjects that say nothing about the change
+var vagueSubject = regexp.MustCompile(`(?i)^(updated?|changed?|fixed|modified|misc|wip)( (code|files?|stuff|things|changes))?\.?$`)
+
+// Check a message against the rules gitdone's prompt asks for, returning the broken ones
+func lintMessage(msg string) []string {
+	problems := []string{}
+	lines := strings.Split(msg, "\n")
+	subject := lines[0]
+	if strings.TrimSpace(subject) == "" {
+		return append(problems, "empty subject")
+	}
+	if len(subject) > 72 {
+		problems = append(problems, "subject over 72 characters")
+	}
+	if strings.HasSuffix(subject, ".") {
+		problems = append(problems, "subject ends with a period")
+	}
+	// Module scopes come before the sentence the rules apply to
+	sentence := strings.TrimSpace(scopePrefix.ReplaceAllString(subject, ""))
+	words := strings.Fields(sentence)
+	if len(words) == 0 {
+		return append(problems, "empty subject")
+	}
+	if first := []rune(sentence)[0]; unicode.IsLetter(first) && !unicode.IsUpper(first) {
+		problems = append(problems, "subject not capitalized")
+	}
+	if !strings.HasSuffix(strings.ToLower(words[0]), "ed") && !isPastTense(words[0]) {
+		problems = append(problems, "subject not in past tense")
+	}
+	if vagueSubject.MatchString(sentence) {
+		problems = append(problems, "vague subject")
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		problems = append(problems, "no blank line after subject")
+	}
+	for _, line := range lines {
+		if line != strings.TrimRight(line, " \t") {
+			problems = append(problems, "trailing whitespace")
+			break
+		}
+	}
+	return problems
+}
+
+// Irregular past tenses the lint accepts
+func isPastTense(word string) bool {
+	switch strings.ToLower(word) {
+	case "made", "built", "wrote", "rewrote", "split", "set", "reset", "put", "kept", "ran", "read":
+		return true
+	}
+	return false
+}
+
+// Lowercase words of a message, for comparing with the reference
+func messageWords(msg string) []string {
+	return strings.FieldsFunc(strings.ToLower(msg), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Jaccard similarity of the word sets of two messages
+func wordOverlap(a, b []string) float64 {
+	set := make(map[string]int)
+	for _, word := range a {
+		set[word] |= 1
+	}
+	for _, word := range b {
+		set[word] |= 2
+	}
+	if len(set) == 0 {
+		return 0
+	}
+	both := 0
+	for _, in := range set {
+		if in == 3 {
+			both++
+		}
+	}
+	return float64(both) / float64(len(set))
+}
+
+// F1 of the longest common subsequence of words, as ROUGE-L scores it
+func lcsScore(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	lcs := float64(prev[len(b)])
+	if lcs == 0 {
+		return 0
+	}
+	precision, recall := lcs/float64(len(a)), lcs/float64(len(b))
+	return 2 * precision * recall / (precision + recall)
+}
+
+// Score a generated message against the reference
+func scoreMessage(name, msg, reference string) evalResult {
+	words, referenceWords := messageWords(msg), messageWords(reference)
+	return evalResult{
+		Case:    name,
+		Message: msg,
+		Lint:    lintMessage(msg),
+		Overlap: wordOverlap(words, referenceWords),
+		LCS:     lcsScore(words, referenceWords),
+	}
+}
+
+// Run every case through the summarizer and message generation, each in a
+// scratch repository of its own, so the result doesn't depend on the
+// repository eval runs in
+func runEval(ctx context.Context, dir string, cases []evalCase) (*evalReport, error) {
+	previous := gitClient
+	defer func() { gitClient = previous }()
+
+	report := &evalReport{Results: []evalResult{}}
+	for _, c := range cases {
+		info("Evaluating %s...\n", c.Name)
+		msg, err := evalMessage(ctx, dir, c)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			report.Results = append(report.Results, evalResult{Case: c.Name, Lint: []string{}, Error: err.Error()})
+			continue
+		}
+		report.Results = append(report.Results, scoreMessage(c.Name, msg, c.Reference))
+	}
+	return report, nil
+}
+
+// Generate the message for one case the way a commit would, with the case's
+// diff staged on top of its base files in a scratch repository
+func evalMessage(ctx context.Context, dir string, c evalCase) (string, error) {
+	scratch, err := os.MkdirTemp("", "gitdone-eval-")
+	if err != nil {
+		return "", fmt.Errorf("Error creating scratch repository: %v", err)
+	}
+	defer os.RemoveAll(scratch)
+	gitClient = execGit{Dir: scratch}
+
+	if err := stageEvalCase(ctx, dir, scratch, c); err != nil {
+		return "", err
+	}
+	cfg, err := loadConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	files, err := readStagedChanges(ctx)
+	if err != nil {
+		return "", err
+	}
+	modules, changeSummary, err := summarizeFiles(ctx, files, stagedChanges, cfg)
+	if err != nil {
+		return "", err
+	}
+	msg, _, err := draftCommitMessage(ctx, changeSummary, modules, stagedChanges, nil, cfg)
+	return msg, err
+}
+
+// Commit the case's base files and the corpus's .gitdone.json in the scratch
+// repository, then apply the case's diff to the index and work tree
+func stageEvalCase(ctx context.Context, dir, scratch string, c evalCase) error {
+	if _, err := gitClient.Run(ctx, "init", "-q"); err != nil {
+		return fmt.Errorf("Error creating scratch repository: %v", err)
+	}
+	if c.Base != "" {
+		if err := copyTree(c.Base, scratch); err != nil {
+			return fmt.Errorf("Error copying the base files of %s: %v", c.Name, err)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, configFileName)); err == nil {
+		if err := os.WriteFile(filepath.Join(scratch, configFileName), data, 0644); err != nil {
+			return fmt.Errorf("Error copying %s: %v", configFileName, err)
+		}
+	}
+	// No case touches this file, so no diff can be the exact undo of the
+	// base commit and come out as a revert
+	if err := os.WriteFile(filepath.Join(scratch, ".gitdone-eval"), []byte(c.Name+"\n"), 0644); err != nil {
+		return fmt.Errorf("Error creating scratch repository: %v", err)
+	}
+	if _, err := gitClient.Run(ctx, "add", "-A"); err != nil {
+		return fmt.Errorf("Error staging the base files of %s: %v", c.Name, err)
+	}
+	if _, err := gitClient.Run(ctx, "-c", "user.name=gitdone", "-c", "user.email=gitdone@localhost", "-c", "commit.gpgsign=false",
+		"commit", "-q", "-m", "Started eval"); err != nil {
+		return fmt.Errorf("Error creating scratch repository: %v", err)
+	}
+	if _, err := gitClient.RunInput(ctx, strings.NewReader(c.Diff), "apply", "--index"); err != nil {
+		return fmt.Errorf("Diff does not apply to its base files: %v", err)
+	}
+	return nil
+}
+
+// Copy the files under src into dst, keeping their paths and permissions
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, stat.Mode().Perm())
+	})
+}
+
+func loadEvalReport(file string) (*evalReport, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %v", file, err)
+	}
+	var report evalReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", file, err)
+	}
+	return &report, nil
+}
+
+func saveEvalReport(file string, report *evalReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshaling results: %v", err)
+	}
+	return os.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// Averages over the cases that produced a message
+func (r *evalReport) averages() (lint, overlap, lcs float64) {
+	n := 0
+	for _, result := range r.Results {
+		if result.Error != "" {
+			continue
+		}
+		lint += float64(len(result.Lint))
+		overlap += result.Overlap
+		lcs += result.LCS
+		n++
+	}
+	if n == 0 {
+		return 0, 0, 0
+	}
+	return lint / float64(n), overlap / float64(n), lcs / float64(n)
+}
+
+// Print the scores per case, next to the baseline's when there is one
+func printEvalTable(out io.Writer, report, baseline *evalReport) {
+	before := make(map[string]evalResult)
+	if baseline != nil {
+		for _, result := range baseline.Results {
+			before[result.Case] = result
+		}
+	}
+	cell := func(format string, after float64, old *float64) string {
+		if old == nil {
+			return fmt.Sprintf(format, after)
+		}
+		return fmt.Sprintf(format+" → "+format, *old, after)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CASE\tLINT\tOVERLAP\tLCS\tMESSAGE")
+	for _, result := range report.Results {
+		if result.Error != "" {
+			fmt.Fprintf(w, "%s\t-\t-\t-\terror: %s\n", result.Case, result.Error)
+			continue
+		}
+		var oldLint, oldOverlap, oldLCS *float64
+		if old, ok := before[result.Case]; ok && old.Error == "" {
+			lint := float64(len(old.Lint))
+			oldLint, oldOverlap, oldLCS = &lint, &old.Overlap, &old.LCS
+		}
+		subject, _, _ := strings.Cut(result.Message, "\n")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Case,
+			cell("%.0f", float64(len(result.Lint)), oldLint),
+			cell("%.2f", result.Overlap, oldOverlap),
+			cell("%.2f", result.LCS, oldLCS), subject)
+	}
+
+	lint, overlap, lcs := report.averages()
+	if baseline != nil {
+		oldLint, oldOverlap, oldLCS := baseline.averages()
+		fmt.Fprintf(w, "average\t%s\t%s\t%s\t\n", cell("%.2f", lint, &oldLint), cell("%.2f", overlap, &oldOverlap), cell("%.2f", lcs, &oldLCS))
+	} else {
+		fmt.Fprintf(w, "average\t%.2f\t%.2f\t%.2f\t\n", lint, overlap, lcs)
+	}
+	w.Flush()
+}
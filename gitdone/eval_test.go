@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLintMessage(t *testing.T) {
+	for msg, want := range map[string][]string{
+		"Added retries to send in client.go":          {},
+		"api, web: Renamed the session cookie":        {},
+		"adds retries.":                               {"subject ends with a period", "subject not capitalized", "subject not in past tense"},
+		"Updated code":                                {"vague subject"},
+		"Fixed parser\nbody right after the subject ": {"no blank line after subject", "trailing whitespace"},
+		strings.Repeat("Added ", 13):                  {"subject over 72 characters", "trailing whitespace"},
+	} {
+		if got := lintMessage(msg); !reflect.DeepEqual(got, want) {
+			t.Errorf("lintMessage(%q) = %q, want %q", msg, got, want)
+		}
+	}
+}
+
+func TestSimilarityScores(t *testing.T) {
+	reference := messageWords("Added retries to send")
+	if got := wordOverlap(messageWords("Added retries to send"), reference); got != 1 {
+		t.Errorf("overlap of equal messages = %v, want 1", got)
+	}
+	if got := lcsScore(messageWords("Fixed the parser"), reference); got != 0 {
+		t.Errorf("lcs of unrelated messages = %v, want 0", got)
+	}
+	// "added retries send" is common: precision 3/4, recall 3/4
+	if got := lcsScore(messageWords("Added retries in send"), reference); got != 0.75 {
+		t.Errorf("lcs = %v, want 0.75", got)
+	}
+}
+
+func TestEvalCorpusWithStubModel(t *testing.T) {
+	newTestRepo(t)
+	cases, err := loadEvalCorpus("testdata/eval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := model
+	model = &recordedModel{Stub: true}
+	t.Cleanup(func() { model = previous })
+
+	report, err := runEval(context.Background(), "testdata/eval", cases)
+	if err != nil {
+		t.Fatalf("runEval: %v", err)
+	}
+	if len(report.Results) != 4 {
+		t.Fatalf("got %d results, want 4", len(report.Results))
+	}
+	for _, result := range report.Results {
+		want := "Updated "
+		// Staged on top of its go.mod, the bump is recognized without the model
+		if result.Case == "bump-color" {
+			want = "Bumped github.com/fatih/color from v1.16.0 to v1.17.0"
+		}
+		if result.Error != "" || !strings.HasPrefix(result.Message, want) {
+			t.Errorf("%s: %+v", result.Case, result)
+		}
+	}
+
+	var out bytes.Buffer
+	printEvalTable(&out, report, report)
+	if !strings.Contains(out.String(), "retry-send") || !strings.Contains(out.String(), " → ") {
+		t.Errorf("comparison table:\n%s", out.String())
+	}
+}
+
+func TestEvalRecordsAndReplays(t *testing.T) {
+	newTestRepo(t)
+	dir := t.TempDir()
+	for _, name := range []string{"retry-send.diff", "retry-send.msg", "retry-send.base/client.go"} {
+		data, err := os.ReadFile(filepath.Join("testdata/eval", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+	cases, err := loadEvalCorpus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newFakeModelServer(t, "Retried send with backoff")
+	recorder := &recordedModel{Live: model, Responses: map[string]string{}}
+	model = recorder
+	recorded, err := runEval(context.Background(), dir, cases)
+	if err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	if err := saveRecordings(dir, recorder.Responses); err != nil {
+		t.Fatal(err)
+	}
+
+	responses, err := loadRecordings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model = &recordedModel{Responses: responses}
+	replayed, err := runEval(context.Background(), dir, cases)
+	if err != nil {
+		t.Fatalf("replaying: %v", err)
+	}
+	if !reflect.DeepEqual(replayed, recorded) || replayed.Results[0].Message != "Retried send with backoff" {
+		t.Errorf("replayed %+v, recorded %+v", replayed, recorded)
+	}
+	if got := len(server.receivedPrompts()); got != 1 {
+		t.Errorf("model got %d prompts, want 1 while recording and none while replaying", got)
+	}
+}
+
+func TestEvalCaseWithoutItsBaseFails(t *testing.T) {
+	newTestRepo(t)
+	previous := model
+	model = &recordedModel{Stub: true}
+	t.Cleanup(func() { model = previous })
+
+	diff, err := os.ReadFile("testdata/eval/retry-send.diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := runEval(context.Background(), t.TempDir(), []evalCase{{Name: "retry-send", Diff: string(diff), Reference: "Retried send"}})
+	if err != nil {
+		t.Fatalf("runEval: %v", err)
+	}
+	if result := report.Results[0]; !strings.Contains(result.Error, "does not apply") {
+		t.Errorf("result = %+v, want the diff to fail to apply", result)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Paths that deserve a closer look in review, and why
+var riskyPaths = []struct {
+	Pattern *regexp.Regexp
+	Area    string
+}{
+	{regexp.MustCompile(`(?i)(auth|login|session|password|token|secret|crypt|permission)`), "authentication and secrets"},
+	{regexp.MustCompile(`(?i)(migrations?|schema)[/._]`), "database schema"},
+	{regexp.MustCompile(`(?i)(^|/)(\.github/workflows|\.gitlab-ci|jenkinsfile|dockerfile|docker-compose)`), "build and deployment"},
+	{regexp.MustCompile(`(?i)(^|/)(config|settings)[^/]*\.(json|ya?ml|toml|ini|env)$|(^|/)\.env`), "configuration"},
+}
+
+// List the risky areas a change touches, from its paths and file operations
+func riskyAreas(files []*fileChanges) []string {
+	var areas []string
+	add := func(area string) {
+		if !containsString(areas, area) {
+			areas = append(areas, area)
+		}
+	}
+	for _, file := range files {
+		for _, risky := range riskyPaths {
+			if risky.Pattern.MatchString(file.Path) {
+				add(risky.Area + " (" + path.Base(file.Path) + ")")
+			}
+		}
+		if len(file.Dependencies) > 0 {
+			add("dependencies (" + file.Path + ")")
+		}
+		if file.Deleted {
+			add("deleted files")
+		}
+		for _, operation := range file.Operations {
+			if strings.HasPrefix(operation, "Made ") || strings.HasPrefix(operation, "Changed mode") {
+				add("file permissions")
+			}
+		}
+	}
+	return areas
+}
+
+// Get the changes, their sides and the original messages for a commit or a
+// range. Git resolves the target, so A..B, A...B, A^! and the like all work.
+func explainTarget(ctx context.Context, target string) ([]*fileChanges, diffSource, string, error) {
+	// Anything else on the command line would be taken as an option
+	if strings.HasPrefix(target, "-") {
+		return nil, diffSource{}, "", fmt.Errorf("Unknown commit or range %s", target)
+	}
+	out, err := gitClient.Run(ctx, "rev-parse", "--revs-only", target)
+	if err != nil {
+		return nil, diffSource{}, "", fmt.Errorf("Unknown commit or range %s: %v", target, err)
+	}
+	var tips, excluded []string
+	for _, rev := range strings.Fields(out) {
+		if strings.HasPrefix(rev, "^") {
+			excluded = append(excluded, strings.TrimPrefix(rev, "^"))
+		} else {
+			tips = append(tips, rev)
+		}
+	}
+
+	switch {
+	case len(tips) == 1 && len(excluded) == 0:
+		return explainCommit(ctx, target)
+	// A..B gives B and ^A; A...B gives B, A and ^ their merge base
+	case len(tips) >= 1 && len(tips) <= 2 && len(excluded) == 1:
+		return explainRange(ctx, target, excluded[0], tips[0])
+	case len(tips) == 2 && len(excluded) == 0:
+		return nil, diffSource{}, "", fmt.Errorf("The sides of %s have no common history", target)
+	case len(tips) == 0:
+		return nil, diffSource{}, "", fmt.Errorf("Unknown commit or range %s", target)
+	}
+	return nil, diffSource{}, "", fmt.Errorf("Can't explain %s; give one commit, A..B or A...B", target)
+}
+
+// Explain a range by what tip changed since it forked from before: the diff
+// from their merge base to tip, as git diff A...B shows it, with the messages
+// of the commits git log A..B lists. Changes made only on before's side are
+// left out, even for A..B, where git diff would show them reversed.
+func explainRange(ctx context.Context, target, before, tip string) ([]*fileChanges, diffSource, string, error) {
+	base, err := gitClient.Run(ctx, "merge-base", before, tip)
+	if err != nil {
+		return nil, diffSource{}, "", fmt.Errorf("Invalid range %s: %v", target, err)
+	}
+	source := diffSource{Before: strings.TrimSpace(base), After: tip}
+	files, err := streamDiffChanges(ctx, "diff", "--no-color", "-M", "--submodule=short", source.Before, source.After)
+	if err != nil {
+		return nil, diffSource{}, "", fmt.Errorf("Error reading diff of %s: %v", target, err)
+	}
+	messages, err := gitClient.Run(ctx, "log", "--reverse", "--format=- %s", source.Before+".."+source.After)
+	if err != nil {
+		return nil, diffSource{}, "", fmt.Errorf("Error reading messages of %s: %v", target, err)
+	}
+	return files, source, messages, nil
+}
+
+// Explain one commit. A merge is explained by what it brought into its first
+// parent, along with the messages of the commits it merged.
+func explainCommit(ctx context.Context, target string) ([]*fileChanges, diffSource, string, error) {
+	sha, err := gitClient.Run(ctx, "rev-parse", "--verify", "-q", target+"^{commit}")
+	if err != nil {
+		return nil, diffSource{}, "", fmt.Errorf("Unknown commit %s", target)
+	}
+	sha = strings.TrimSpace(sha)
+	// Without -m, git shows a merge as a combined diff of its conflicts only
+	files, err := streamDiffChanges(ctx, "show", "--format=", "--no-color", "-m", "--first-parent", "-M", "--submodule=short", sha)
+	if err != nil {
+		return nil, diffSource{}, "", fmt.Errorf("Error reading diff of %s: %v", shortSHA(sha), err)
+	}
+	message, err := gitClient.Run(ctx, "log", "-1", "--format=%B", sha)
+	if err != nil {
+		return nil, diffSource{}, "", fmt.Errorf("Error reading message of %s: %v", shortSHA(sha), err)
+	}
+	if _, err := gitClient.Run(ctx, "rev-parse", "--verify", "-q", sha+"^2"); err == nil {
+		merged, err := gitClient.Run(ctx, "log", "--reverse", "--no-merges", "--format=- %s", sha+"^1.."+sha)
+		if err != nil {
+			return nil, diffSource{}, "", fmt.Errorf("Error reading the commits merged by %s: %v", shortSHA(sha), err)
+		}
+		message = strings.TrimSpace(message) + "\n\nCommits merged:\n" + merged
+	}
+	return files, commitChanges(sha), message, nil
+}
+
+// Ask the model for a plain-language account of a change and whether its message fits
+func explainChanges(ctx context.Context, changeSummary, messages string, risky []string) (string, error) {
+	info("Explaining changes using Ollama API...\n")
+	riskyList := "none found"
+	if len(risky) > 0 {
+		riskyList = strings.Join(risky, ", ")
+	}
+	prompt := fmt.Sprintf(`Explain these code changes to a reviewer who doesn't know the codebase.
+Answer in plain language with exactly these four sections, each a few sentences at most:
+
+What changed: the concrete changes, naming files and functions
+Why: the most likely reason for the change
+Risky areas: what a reviewer should check carefully, including the areas listed below
+Message check: "Matches" if the original commit message describes the diff, otherwise "Mismatch: " and what it leaves out or gets wrong
+
+Risky areas detected from the paths: %s
+
+Original commit message(s):
+%s
+
+Changes to analyze:
+%s`, riskyList, strings.TrimSpace(messages), changeSummary)
+
+	explanation, err := model.Generate(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	explanation = strings.TrimSpace(explanation)
+	if explanation == "" {
+		return "", fmt.Errorf("Generated explanation is empty")
+	}
+	return explanation, nil
+}
+
+// Check whether an explanation says the message doesn't match the diff
+func messageMismatch(explanation string) bool {
+	for _, line := range strings.Split(explanation, "\n") {
+		line = strings.ToLower(strings.TrimLeft(line, "*#- "))
+		if strings.HasPrefix(line, "message check") {
+			return strings.Contains(line, "mismatch")
+		}
+	}
+	return false
+}
+
+// Explain a commit or range in plain language
+func explain(ctx context.Context, target string, cfg *config) (string, error) {
+	files, source, messages, err := explainTarget(ctx, target)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("%s has no changes to explain", target)
+	}
+
+	_, changeSummary, err := summarizeFiles(ctx, files, source, cfg)
+	if err != nil {
+		return "", err
+	}
+	return explainChanges(ctx, changeSummary, messages, riskyAreas(files))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExplainCommitFlagsRiskAndMismatch(t *testing.T) {
+	repo := newTestRepo(t)
+	response := "What changed: auth.go now skips the token check.\nWhy: probably to debug logins.\nRisky areas: authentication.\nMessage check: Mismatch: the message only mentions typos."
+	server := newFakeModelServer(t, response)
+	repo.write("auth.go", "package main\n\nfunc checkToken(token string) bool { return true }\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Fixed typos")
+
+	explanation, err := explain(context.Background(), "HEAD", repo.config())
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	if explanation != response {
+		t.Errorf("explanation = %q, want the model response unchanged", explanation)
+	}
+	if !messageMismatch(explanation) {
+		t.Errorf("mismatch not detected in %q", explanation)
+	}
+
+	prompts := server.receivedPrompts()
+	if len(prompts) != 1 {
+		t.Fatalf("got %d prompts, want 1", len(prompts))
+	}
+	for _, want := range []string{"Fixed typos", "authentication and secrets (auth.go)", "checkToken"} {
+		if !strings.Contains(prompts[0], want) {
+			t.Errorf("prompt is missing %q:\n%s", want, prompts[0])
+		}
+	}
+}
+
+func TestExplainRangeCoversEveryCommit(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "What changed: two files.\nMessage check: Matches")
+	base := repo.git("rev-parse", "HEAD")
+	repo.write("a.go", "package main\n\nvar a = 1\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added a")
+	repo.write("b.go", "package main\n\nvar b = 2\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added b")
+
+	explanation, err := explain(context.Background(), base+"..", repo.config())
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	if messageMismatch(explanation) {
+		t.Errorf("mismatch reported for %q", explanation)
+	}
+	prompt := server.receivedPrompts()[0]
+	for _, want := range []string{"- Added a\n- Added b", "In a.go", "In b.go"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt is missing %q:\n%s", want, prompt)
+		}
+	}
+}
+
+// Build a feature branch with one commit, and a main that moved on
+// without it, returning to main
+func divergedBranches(repo *testRepo) {
+	repo.git("checkout", "-q", "-b", "feature")
+	repo.write("feature.go", "package main\n\nvar feature = true\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added feature flag")
+	repo.git("checkout", "-q", "main")
+	repo.write("hotfix.go", "package main\n\nvar hotfix = true\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added hotfix")
+}
+
+func TestExplainSymmetricRange(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "What changed: a flag.\nMessage check: Matches")
+	divergedBranches(repo)
+
+	if _, err := explain(context.Background(), "main...feature", repo.config()); err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	// Like git diff A...B, only what feature did since it forked
+	prompt := server.receivedPrompts()[0]
+	if !strings.Contains(prompt, "In feature.go") || !strings.Contains(prompt, "- Added feature flag") {
+		t.Errorf("prompt is missing the feature branch:\n%s", prompt)
+	}
+	if strings.Contains(prompt, "hotfix") {
+		t.Errorf("prompt includes main's own changes:\n%s", prompt)
+	}
+}
+
+func TestExplainMergeCommit(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "What changed: merged the flag.\nMessage check: Matches")
+	divergedBranches(repo)
+	repo.git("merge", "-q", "--no-ff", "-m", "Merge branch 'feature'", "feature")
+
+	if _, err := explain(context.Background(), "HEAD", repo.config()); err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	prompt := server.receivedPrompts()[0]
+	for _, want := range []string{"In feature.go", "Merge branch 'feature'", "Commits merged:\n- Added feature flag"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt is missing %q:\n%s", want, prompt)
+		}
+	}
+	if strings.Contains(prompt, "hotfix") {
+		t.Errorf("prompt includes changes already on the first parent:\n%s", prompt)
+	}
+}
+
+func TestExplainUnknownCommit(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "unused")
+	for _, target := range []string{"nosuchref", "nosuchref..HEAD", "--all"} {
+		if _, err := explain(context.Background(), target, repo.config()); err == nil {
+			t.Errorf("explain of %s succeeded", target)
+		}
+	}
+}
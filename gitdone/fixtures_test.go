@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+func TestMain(m *testing.M) {
+	// Keep progress output out of test logs
+	color.Output = io.Discard
+	retryDelay = 10 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+// A fake Ollama server speaking the generate and embeddings APIs
+type fakeModelServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	response string                   // Text streamed back from /api/generate
+	status   int                      // Status code for /api/generate, 200 if zero
+	delay    time.Duration            // Delay before answering /api/generate
+	prompts  []string                 // Prompts received by /api/generate
+	options  []map[string]interface{} // Options sent with each prompt
+	warmups  int                      // Warm-up requests received, which have no prompt
+	stalled  bool                     // Never answer warm-up requests, like a model that takes ages to load
+	embedded int                      // Embedding requests answered
+	embedMax int                      // Fail embedding requests after this many, unlimited if zero
+	release  chan struct{}            // Closed when the test ends, unblocking delayed handlers
+}
+
+func newFakeModelServer(t *testing.T, response string) *fakeModelServer {
+	t.Helper()
+	f := &fakeModelServer{response: response, release: make(chan struct{})}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(func() {
+		close(f.release)
+		f.Close()
+	})
+
+	previous := model
+	model = &ollamaClient{
+		GenerateURL:    f.URL + "/api/generate",
+		EmbeddingsURL:  f.URL + "/api/embeddings",
+		Model:          "test-model",
+		EmbeddingModel: embeddingModel,
+		Timeout:        5 * time.Second,
+	}
+	t.Cleanup(func() { model = previous })
+	return f
+}
+
+func (f *fakeModelServer) set(fn func(f *fakeModelServer)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f)
+}
+
+func (f *fakeModelServer) receivedWarmups() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.warmups
+}
+
+func (f *fakeModelServer) receivedPrompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.prompts...)
+}
+
+func (f *fakeModelServer) receivedOptions() []map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]map[string]interface{}(nil), f.options...)
+}
+
+func (f *fakeModelServer) handle(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Prompt  string                 `json:"prompt"`
+		Options map[string]interface{} `json:"options"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/embeddings":
+		f.mu.Lock()
+		failing := f.embedMax > 0 && f.embedded >= f.embedMax
+		if !failing {
+			f.embedded++
+		}
+		f.mu.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// Letter counts make a crude but deterministic embedding
+		vector := make([]float64, 26)
+		for _, c := range strings.ToLower(body.Prompt) {
+			if c >= 'a' && c <= 'z' {
+				vector[c-'a']++
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"embedding": vector})
+
+	case "/api/generate":
+		if body.Prompt == "" {
+			// A warm-up request only loads the model
+			f.mu.Lock()
+			f.warmups++
+			stalled := f.stalled
+			f.mu.Unlock()
+			if stalled {
+				select {
+				case <-f.release:
+				case <-r.Context().Done():
+				}
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"response": "", "done": true})
+			return
+		}
+		f.mu.Lock()
+		f.prompts = append(f.prompts, body.Prompt)
+		f.options = append(f.options, body.Options)
+		response, status, delay := f.response, f.status, f.delay
+		f.mu.Unlock()
+
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-f.release:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if status != 0 && status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		// Stream the response in two chunks, as Ollama does
+		encoder := json.NewEncoder(w)
+		half := len(response) / 2
+		encoder.Encode(map[string]interface{}{"response": response[:half], "done": false})
+		encoder.Encode(map[string]interface{}{"response": response[half:], "done": false})
+		encoder.Encode(map[string]interface{}{"response": "", "done": true})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// A temporary repository with one pushed commit and a bare origin remote
+type testRepo struct {
+	t      *testing.T
+	Dir    string
+	Remote string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	repo := &testRepo{t: t, Dir: t.TempDir(), Remote: t.TempDir()}
+
+	previous := gitClient
+	t.Cleanup(func() { gitClient = previous })
+
+	runGitIn(t, repo.Remote, "init", "--bare", "-b", "main")
+	gitClient = execGit{Dir: repo.Dir}
+	repo.git("init", "-b", "main")
+	repo.git("config", "user.name", "Test User")
+	repo.git("config", "user.email", "test@example.com")
+	repo.git("config", "commit.gpgsign", "false")
+	repo.git("remote", "add", "origin", repo.Remote)
+
+	repo.write("main.go", "package main\n\nfunc main() {}\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added main package")
+	repo.git("push", "origin", "main")
+	return repo
+}
+
+func runGitIn(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	out, err := execGit{Dir: dir}.Run(context.Background(), args...)
+	if err != nil {
+		t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return out
+}
+
+// Run git in the repository and fail the test on error
+func (r *testRepo) git(args ...string) string {
+	r.t.Helper()
+	return strings.TrimSpace(runGitIn(r.t, r.Dir, args...))
+}
+
+// Write a file relative to the repository root
+func (r *testRepo) write(name, content string) {
+	r.t.Helper()
+	path := filepath.Join(r.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// Load the repository config the way main does
+func (r *testRepo) config() *config {
+	r.t.Helper()
+	cfg, err := loadConfig(context.Background())
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return cfg
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Get the paths git diff --numstat lists
+func numstatPaths(numstat string) map[string]bool {
+	paths := make(map[string]bool)
+	for _, line := range strings.Split(numstat, "\n") {
+		if parts := strings.SplitN(line, "\t", 3); len(parts) == 3 {
+			paths[parts[2]] = true
+		}
+	}
+	return paths
+}
+
+// Mark the files whose changes are only whitespace or blank lines. git diff
+// -w leaves such files out entirely, so they are the ones it lists only
+// without -w. Files git can't compare, such as in a root commit, are left as they are.
+func markFormattingChanges(ctx context.Context, files []*fileChanges, source diffSource) error {
+	if len(files) == 0 {
+		return nil
+	}
+	var all, significant string
+	err := concurrently(
+		func() (err error) {
+			args := append([]string{"diff", "--numstat", "--no-renames"}, source.diffArgs()...)
+			all, err = gitClient.Run(ctx, args...)
+			return err
+		},
+		func() (err error) {
+			args := append([]string{"diff", "--numstat", "--no-renames", "-w", "--ignore-blank-lines"}, source.diffArgs()...)
+			significant, err = gitClient.Run(ctx, args...)
+			return err
+		},
+	)
+	if err != nil {
+		return ctx.Err()
+	}
+
+	changed, changedBeyondWhitespace := numstatPaths(all), numstatPaths(significant)
+	for _, file := range files {
+		// Renames, new files and the like are more than formatting
+		if !changed[file.Path] || changedBeyondWhitespace[file.Path] || len(file.Operations) > 0 || file.Generated != "" {
+			continue
+		}
+		file.Reformatted = true
+		file.Changes = nil
+	}
+	return nil
+}
+
+// Describe a change that only reformats files without the model, which would
+// otherwise read functional changes into it. Reports false if anything else changed.
+func formattingMessage(modules []*moduleChanges) (string, bool) {
+	var paths []string
+	for _, module := range modules {
+		for _, file := range module.Files {
+			if !file.Reformatted {
+				return "", false
+			}
+			paths = append(paths, file.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return "", false
+	}
+
+	if msg := "Reformatted " + strings.Join(paths, ", "); len(msg) <= 72 {
+		return msg, true
+	}
+	return fmt.Sprintf("Reformatted %d files", len(paths)), true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReformattingSkipsModel(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Changed the return value of answer")
+	repo.write("answer.go", "package main\n\nfunc answer() int {\n\treturn 42\n}\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added answer")
+
+	repo.write("answer.go", "package main\n\n\nfunc answer() int  {\n    return 42\n}\n")
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	if got := repo.git("log", "-1", "--format=%B"); got != "Reformatted answer.go" {
+		t.Errorf("commit message = %q", got)
+	}
+	if prompts := server.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("the model was asked about a whitespace change: %q", prompts)
+	}
+}
+
+func TestReformattedFileLabelledInSummary(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added limit to config")
+	repo.write("answer.go", "package main\n\nfunc answer() int {\n\treturn 42\n}\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added answer")
+
+	repo.write("answer.go", "package main\n\nfunc answer() int {\n        return 42\n}\n")
+	repo.write("config.go", "package main\n\nconst limit = 3\n")
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult()); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	prompts := server.receivedPrompts()
+	if len(prompts) != 1 || !strings.Contains(prompts[0], "Reformatted answer.go (whitespace only)") || strings.Contains(prompts[0], "return 42") {
+		t.Errorf("prompt should label answer.go as reformatted: %q", prompts)
+	}
+}
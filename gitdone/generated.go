@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const ignoreFileName = ".gitdoneignore"
+
+// Lock files and similar outputs of package managers, by base name
+var lockFileNames = map[string]bool{
+	"package-lock.json":   true,
+	"npm-shrinkwrap.json": true,
+	"yarn.lock":           true,
+	"pnpm-lock.yaml":      true,
+	"bun.lockb":           true,
+	"go.sum":              true,
+	"Cargo.lock":          true,
+	"poetry.lock":         true,
+	"Pipfile.lock":        true,
+	"Gemfile.lock":        true,
+	"composer.lock":       true,
+	"mix.lock":            true,
+	"flake.lock":          true,
+	"Podfile.lock":        true,
+	"pubspec.lock":        true,
+	"packages.lock.json":  true,
+}
+
+// Suffixes of files produced by code generators
+var generatedSuffixes = []string{
+	".pb.go", ".pb.gw.go", "_pb2.py", "_pb2_grpc.py", ".pb.cc", ".pb.h",
+	".min.js", ".min.css", ".js.map", ".css.map",
+}
+
+// Directories holding vendored third-party code
+var vendorDirs = []string{"vendor/", "node_modules/", "third_party/"}
+
+// Generator headers: Go's "Code generated ... DO NOT EDIT." and the common @generated marker
+var generatedHeader = regexp.MustCompile(`Code generated .* DO NOT EDIT|@generated`)
+
+// Number of bytes at the top of a file searched for a generator header
+const generatedHeaderBytes = 1024
+
+// Get why a file is considered generated from its name alone, or "" if it isn't
+func generatedByName(file string) string {
+	if lockFileNames[path.Base(file)] {
+		return "lock file"
+	}
+	for _, suffix := range generatedSuffixes {
+		if strings.HasSuffix(file, suffix) {
+			return "generated code"
+		}
+	}
+	for _, dir := range vendorDirs {
+		if strings.HasPrefix(file, dir) || strings.Contains(file, "/"+dir) {
+			return "vendored code"
+		}
+	}
+	return ""
+}
+
+// Check the top of a file, as the diff leaves it, for a generator header
+func hasGeneratedHeader(ctx context.Context, source diffSource, file string) bool {
+	head := readRevisionFile(ctx, source.After, file)
+	if len(head) > generatedHeaderBytes {
+		head = head[:generatedHeaderBytes]
+	}
+	return generatedHeader.MatchString(head)
+}
+
+// Find paths that .gitattributes marks as linguist-generated or linguist-vendored
+func linguistGenerated(ctx context.Context, root string, paths []string) (map[string]string, error) {
+	marked := make(map[string]string)
+	if len(paths) == 0 {
+		return marked, nil
+	}
+
+	// Diff paths are relative to the top of the repository
+	args := append([]string{"-C", root, "check-attr", "linguist-generated", "linguist-vendored", "--"}, paths...)
+	out, err := gitClient.Run(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading attributes: %v", err)
+	}
+	// Lines look like "path: linguist-generated: set"
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, ": ")
+		if len(fields) != 3 || (fields[2] != "set" && fields[2] != "true") {
+			continue
+		}
+		if fields[1] == "linguist-generated" {
+			marked[fields[0]] = "linguist-generated"
+		} else if _, ok := marked[fields[0]]; !ok {
+			marked[fields[0]] = "vendored code"
+		}
+	}
+	return marked, nil
+}
+
+// Find paths matching the gitignore-style patterns in .gitdoneignore. The
+// paths are listed in an index of their own, so a file the current index no
+// longer has, such as one a past commit deleted, still matches.
+func gitdoneIgnored(ctx context.Context, root string, paths []string) (map[string]bool, error) {
+	ignored := make(map[string]bool)
+	ignoreFile := filepath.Join(root, ignoreFileName)
+	if _, err := os.Stat(ignoreFile); err != nil || len(paths) == 0 {
+		return ignored, nil
+	}
+
+	dir, err := os.MkdirTemp("", "gitdone-ignore-")
+	if err != nil {
+		return nil, fmt.Errorf("Error applying %s: %v", ignoreFileName, err)
+	}
+	defer os.RemoveAll(dir)
+	env := []string{"GIT_INDEX_FILE=" + filepath.Join(dir, "index")}
+
+	// Only the paths matter, so every entry can name the empty blob, which
+	// update-index doesn't need to exist. Its name depends on the hash format.
+	emptyBlob, err := gitClient.RunInput(ctx, strings.NewReader(""), "hash-object", "--stdin")
+	if err != nil {
+		return nil, fmt.Errorf("Error applying %s: %v", ignoreFileName, err)
+	}
+	args := []string{"-C", root, "update-index", "--add"}
+	for _, file := range paths {
+		args = append(args, "--cacheinfo", "100644,"+strings.TrimSpace(emptyBlob)+","+file)
+	}
+	if _, err := gitClient.RunEnv(ctx, env, args...); err != nil {
+		return nil, fmt.Errorf("Error applying %s: %v", ignoreFileName, err)
+	}
+
+	// Let git apply the patterns so they behave exactly like .gitignore
+	args = append([]string{"-C", root, "ls-files", "--cached", "--ignored", "--exclude-from=" + ignoreFile, "--"}, paths...)
+	out, err := gitClient.RunEnv(ctx, env, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Error applying %s: %v", ignoreFileName, err)
+	}
+	for _, file := range strings.Split(strings.TrimSpace(out), "\n") {
+		if file != "" {
+			ignored[file] = true
+		}
+	}
+	return ignored, nil
+}
+
+// Mark generated, lock, vendored and .gitdoneignore'd files so the summary
+// only notes that they changed. Generator headers are read from the side of
+// source the changes lead to.
+func markGeneratedFiles(ctx context.Context, files []*fileChanges, source diffSource) error {
+	if len(files) == 0 {
+		return nil
+	}
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	var attributes map[string]string
+	var ignored map[string]bool
+	err = concurrently(
+		func() (err error) {
+			attributes, err = linguistGenerated(ctx, root, paths)
+			return err
+		},
+		func() (err error) {
+			ignored, err = gitdoneIgnored(ctx, root, paths)
+			return err
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		switch {
+		case ignored[file.Path]:
+			file.Generated = ignoreFileName
+		case attributes[file.Path] != "":
+			file.Generated = attributes[file.Path]
+		case generatedByName(file.Path) != "":
+			file.Generated = generatedByName(file.Path)
+		case !file.Deleted && hasGeneratedHeader(ctx, source, file.Path):
+			file.Generated = "generated code"
+		}
+		if file.Generated != "" {
+			file.Changes = nil
+		}
+	}
+	return nil
+}
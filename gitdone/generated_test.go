@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSummaryCollapsesGeneratedFiles(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write(".gitdoneignore", "fixtures/\n")
+	repo.write(".gitattributes", "schema.go linguist-generated\n")
+	repo.write("go.sum", "github.com/fatih/color v1.17.0 h1:abc=\n")
+	repo.write("api.pb.go", "package main\n\nfunc (x *Req) Reset() {}\n")
+	repo.write("mock.go", "// Code generated by mockgen. DO NOT EDIT.\npackage main\n\nfunc NewMock() {}\n")
+	repo.write("schema.go", "package main\n\ntype Schema struct{}\n")
+	repo.write("fixtures/data.go", "package main\n\nvar data = 1\n")
+	repo.write("server.go", "package main\n\nfunc serve() {}\n")
+	repo.git("add", ".")
+
+	files, err := readStagedChanges(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, summary, err := summarizeFiles(context.Background(), files, stagedChanges, repo.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"Regenerated go.sum (lock file)",
+		"Regenerated api.pb.go (generated code)",
+		"Regenerated mock.go (generated code)",
+		"Regenerated schema.go (linguist-generated)",
+		"Regenerated fixtures/data.go (.gitdoneignore)",
+		"Added: func serve() {",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary missing %q:\n%s", want, summary)
+		}
+	}
+	for _, unwanted := range []string{"Reset()", "NewMock", "type Schema", "var data"} {
+		if strings.Contains(summary, unwanted) {
+			t.Errorf("summary includes generated content %q:\n%s", unwanted, summary)
+		}
+	}
+}
+
+func TestExplainLabelsGeneratedFilesAsCommitted(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "What changed: mocks.\nMessage check: Matches")
+	repo.write(".gitdoneignore", "fixtures/\n")
+	repo.write("mock.go", "// Code generated by mockgen. DO NOT EDIT.\npackage main\n\nfunc NewMock() {}\n")
+	repo.write("fixtures/data.go", "package main\n\nvar data = 1\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added mocks and fixtures")
+	added := repo.git("rev-parse", "HEAD")
+
+	// Since then the mock became hand-written and the fixtures went away
+	repo.write("mock.go", "package main\n\nfunc NewMock() {}\n")
+	repo.git("rm", "-q", "fixtures/data.go")
+	repo.git("commit", "-qam", "Replaced generated mock")
+
+	if _, err := explain(context.Background(), added, repo.config()); err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	prompt := server.receivedPrompts()[0]
+	for _, want := range []string{"Regenerated mock.go (generated code)", "Regenerated fixtures/data.go (.gitdoneignore)"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q:\n%s", want, prompt)
+		}
+	}
+	if strings.Contains(prompt, "func NewMock") || strings.Contains(prompt, "var data") {
+		t.Errorf("prompt includes generated content:\n%s", prompt)
+	}
+}
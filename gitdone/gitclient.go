@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Runs git subcommands and returns their output
+type gitRunner interface {
+	Run(ctx context.Context, args ...string) (string, error)
+	// Run with extra environment variables, such as GIT_AUTHOR_DATE
+	RunEnv(ctx context.Context, env []string, args ...string) (string, error)
+	// Run with input on stdin, such as a patch for git patch-id
+	RunInput(ctx context.Context, input io.Reader, args ...string) (string, error)
+	// Run and hand the output to read as it is produced, for output too large to buffer
+	Stream(ctx context.Context, read func(io.Reader) error, args ...string) error
+}
+
+// Runs the git executable in Dir, or the current directory if Dir is empty
+type execGit struct {
+	Dir string
+}
+
+func (g execGit) Run(ctx context.Context, args ...string) (string, error) {
+	return runCommandIn(ctx, g.Dir, nil, "git", args...)
+}
+
+func (g execGit) RunEnv(ctx context.Context, env []string, args ...string) (string, error) {
+	return runCommandIn(ctx, g.Dir, env, "git", args...)
+}
+
+func (g execGit) RunInput(ctx context.Context, input io.Reader, args ...string) (string, error) {
+	return runCommandWithInput(ctx, g.Dir, nil, input, "git", args...)
+}
+
+func (g execGit) Stream(ctx context.Context, read func(io.Reader) error, args ...string) error {
+	return streamCommandIn(ctx, g.Dir, read, "git", args...)
+}
+
+// The git used by the commands; tests point it at a temporary repository
+var gitClient gitRunner = execGit{}
+
+// Run independent git queries at the same time, returning the first error in argument order
+func concurrently(queries ...func() error) error {
+	errs := make([]error, len(queries))
+	var wg sync.WaitGroup
+	for i, query := range queries {
+		wg.Add(1)
+		go func(i int, query func() error) {
+			defer wg.Done()
+			errs[i] = query()
+		}(i, query)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,16 +4,17 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -30,14 +31,33 @@ const (
 	ollamaAPIURL            = "http://localhost:11434/api/generate"
 	modelName               = "llama3.1"
 	maxRetries              = 3
-	timeout                 = 180 * time.Second // Increased timeout for larger diffs
 	userTimeout             = 30 * time.Second
 	maxConcurrentOperations = 4
 	maxDiffSize             = 50000 // Maximum diff size before truncating
+	maxSummarySize          = 8000  // Summary size before only the largest changes keep their details
+	maxHunkContexts         = 5     // Enclosing functions listed per file
 )
 
-// Run a shell command and return the output
-func runCommand(name string, args ...string) (string, error) {
+// Variables rather than constants so tests can shorten them
+var (
+	timeout    = 180 * time.Second // Increased timeout for larger diffs
+	retryDelay = time.Second       // Base delay between model API retries
+)
+
+// Run a shell command in the current directory and return the output
+func runCommand(ctx context.Context, name string, args ...string) (string, error) {
+	return runCommandIn(ctx, "", nil, name, args...)
+}
+
+// Run a shell command in dir, or the current directory if dir is empty, with
+// extra environment variables, and return the output. The command is killed
+// if ctx is cancelled.
+func runCommandIn(ctx context.Context, dir string, env []string, name string, args ...string) (string, error) {
+	return runCommandWithInput(ctx, dir, env, nil, name, args...)
+}
+
+// Run a command as runCommandIn does, reading its standard input from stdin if it isn't nil
+func runCommandWithInput(ctx context.Context, dir string, env []string, stdin io.Reader, name string, args ...string) (string, error) {
 	if runtime.GOOS == "windows" {
 		// Handle Git paths on Windows
 		if name == "git" {
@@ -45,133 +65,105 @@ func runCommand(name string, args ...string) (string, error) {
 		}
 	}
 
-	cmd := exec.Command(name, args...)
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Don't wait forever on pipes held open by children such as ssh
+	cmd.WaitDelay = 5 * time.Second
 	var out bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &stderr
+	cmd.Stdin = stdin
 
 	// Set working directory
-	cmd.Dir, _ = os.Getwd()
+	cmd.Dir = dir
+	if dir == "" {
+		cmd.Dir, _ = os.Getwd()
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("Error running command: %w", ctx.Err())
+	}
 	if err != nil {
 		return "", fmt.Errorf("Error running command: %v\nStderr: %s", err, stderr.String())
 	}
 	return out.String(), nil
 }
 
-// Add all changes to the staging area
-func addAllChanges() error {
-	info("Adding all changes to the staging area...\n")
-	_, err := runCommand("git", "add", ".")
-	if err != nil {
-		return fmt.Errorf("Error adding changes: %v", err)
+// Run a command in dir, or the current directory if dir is empty, and pass its
+// output to read while it runs. The command is killed if ctx is cancelled or
+// read returns early with an error.
+func streamCommandIn(ctx context.Context, dir string, read func(io.Reader) error, name string, args ...string) error {
+	if runtime.GOOS == "windows" && name == "git" {
+		name = findGitExecutable()
 	}
-	success("All changes added to the staging area.\n")
-	return nil
-}
 
-// Get git diff
-func getGitDiff() (string, error) {
-	info("Getting git diff...\n")
-	diff, err := runCommand("git", "diff", "--cached")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = 5 * time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Dir = dir
+	if dir == "" {
+		cmd.Dir, _ = os.Getwd()
+	}
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return "", err
+		return fmt.Errorf("Error running command: %v", err)
 	}
-	return diff, nil
-}
-
-// Call Ollama API with a given prompt
-func callOllamaAPI(prompt string) (string, error) {
-	requestBody := map[string]interface{}{
-		"model":       modelName,
-		"prompt":      prompt,
-		"temperature": 0.2,
-		"stream":      true,
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Error running command: %v", err)
 	}
 
-	jsonBody, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("Error marshaling request body: %v", err)
+	readErr := read(stdout)
+	if readErr != nil {
+		cancel()
+	} else {
+		// Let the command finish even if read stopped before the end
+		io.Copy(io.Discard, stdout)
 	}
-
-	// Create a client with custom timeout and keep-alive settings
-	client := &http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 100,
-			IdleConnTimeout:     90 * time.Second,
-		},
+	err = cmd.Wait()
+	switch {
+	case readErr != nil:
+		return readErr
+	case ctx.Err() != nil:
+		return fmt.Errorf("Error running command: %w", ctx.Err())
+	case err != nil:
+		return fmt.Errorf("Error running command: %v\nStderr: %s", err, stderr.String())
 	}
+	return nil
+}
 
-	var responseText string
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, "POST", ollamaAPIURL, bytes.NewBuffer(jsonBody))
-		if err != nil {
-			return "", fmt.Errorf("Error creating request: %v", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := client.Do(req)
-		if err != nil {
-			if attempt < maxRetries {
-				time.Sleep(time.Duration(attempt) * time.Second)
-				continue
-			}
-			return "", err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			if attempt < maxRetries {
-				time.Sleep(time.Duration(attempt) * time.Second)
-				continue
-			}
-			return "", fmt.Errorf("API returned status code: %d", resp.StatusCode)
-		}
-
-		reader := bufio.NewReader(resp.Body)
-		var fullResponse strings.Builder
-
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return "", fmt.Errorf("Error reading response: %v", err)
-			}
-
-			var ollamaResp map[string]interface{}
-			if err := json.Unmarshal([]byte(line), &ollamaResp); err != nil {
-				continue
-			}
-
-			if response, ok := ollamaResp["response"].(string); ok {
-				fullResponse.WriteString(response)
-			}
-
-			if done, ok := ollamaResp["done"].(bool); ok && done {
-				break
-			}
-		}
-
-		responseText = strings.TrimSpace(fullResponse.String())
-		if responseText != "" {
-			break
-		}
+// Add all changes to the staging area
+func addAllChanges(ctx context.Context) error {
+	info("Adding all changes to the staging area...\n")
+	_, err := gitClient.Run(ctx, "add", ".")
+	if err != nil {
+		return fmt.Errorf("Error adding changes: %v", err)
 	}
+	success("All changes added to the staging area.\n")
+	return nil
+}
 
-	if responseText == "" {
-		return "", fmt.Errorf("Failed to get a valid response after %d attempts", maxRetries)
-	}
+// Read the staged diff into the changes of each file
+func readStagedChanges(ctx context.Context) ([]*fileChanges, error) {
+	info("Getting git diff...\n")
+	return streamDiffChanges(ctx, "diff", "--cached", "-M", "--submodule=short")
+}
 
-	return responseText, nil
+// Run a git command that prints a diff and read the changes of each file as
+// git produces them, so a huge diff is never held in memory
+func streamDiffChanges(ctx context.Context, args ...string) ([]*fileChanges, error) {
+	var files []*fileChanges
+	err := gitClient.Stream(ctx, func(r io.Reader) (err error) {
+		files, err = readDiffChanges(r)
+		return err
+	}, args...)
+	return files, err
 }
 
 // Clean the commit message by removing unwanted phrases
@@ -222,10 +214,9 @@ func cleanCommitMessage(msg string) string {
 	return msg
 }
 
-// Generate commit message using Ollama API
-func generateCommitMessage(changeSummary string) (string, error) {
-	info("Generating commit message using Ollama API...\n")
-	prompt := fmt.Sprintf(`Based on these code changes, write a direct git commit message:
+// Prompt for commit messages, filled in with similar past commits and the change summary.
+// Its hash is recorded with each commit, so editing it shows up in gitdone stats.
+const commitPromptTemplate = `Based on these code changes, write a direct git commit message:
 - Use past tense (Updated, Added, Fixed, etc.)
 - Be specific about what code was changed
 - Keep it under 72 characters
@@ -239,10 +230,16 @@ Added database migration for user table
 Fixed memory leak in image processing
 Refactored API response handling
 
+%s
 Changes to analyze:
-%s`, changeSummary)
+%s`
 
-	commitMsg, err := callOllamaAPI(prompt)
+// Generate commit message using Ollama API, using similar past commits as examples
+func generateCommitMessage(ctx context.Context, changeSummary string, examples string) (string, error) {
+	info("Generating commit message using Ollama API...\n")
+	prompt := fmt.Sprintf(commitPromptTemplate, examples, changeSummary)
+
+	commitMsg, err := model.Generate(ctx, prompt)
 	if err != nil {
 		return "", err
 	}
@@ -278,78 +275,163 @@ func formatCommitMessage(msg string) string {
 	return formattedMsg.String()
 }
 
-// Automate git commit and push
-func gitCommitAndPush(commitMsg string) error {
+// Commit the staged changes and return the new commit's SHA
+func gitCommit(ctx context.Context, commitMsg string, opts commitOptions) (string, error) {
 	info("Starting git operations...\n")
 
-	status, err := runCommand("git", "status", "--porcelain")
+	// The caller has already checked for staged changes; a merge may have none
+	args := append([]string{"commit", "-m", commitMsg}, signingArgs(opts)...)
+	_, err := gitClient.Run(ctx, args...)
 	if err != nil {
-		return fmt.Errorf("Error checking git status: %v", err)
-	}
-	if status == "" {
-		warn("No changes to commit.\n")
-		return nil
+		return "", fmt.Errorf("Error committing changes: %v", err)
 	}
+	success("Committed changes with message:\n%s\n", commitMsg)
 
-	_, err = runCommand("git", "commit", "-m", commitMsg)
+	sha, err := gitClient.Run(ctx, "rev-parse", "HEAD")
 	if err != nil {
-		return fmt.Errorf("Error committing changes: %v", err)
+		return "", fmt.Errorf("Error reading new commit: %v", err)
 	}
-	success("Committed changes with message:\n%s\n", commitMsg)
+	sha = strings.TrimSpace(sha)
 
-	branch, err := runCommand("git", "rev-parse", "--abbrev-ref", "HEAD")
-	if err != nil {
-		return fmt.Errorf("Error getting current branch: %v", err)
+	// Never push a commit that was meant to be signed but isn't
+	if signingEnabled(ctx, opts) {
+		if err := verifyCommitSignature(ctx, sha); err != nil {
+			return sha, err
+		}
 	}
-	branch = strings.TrimSpace(branch)
+	return sha, nil
+}
 
-	_, err = runCommand("git", "push", "origin", branch)
+// Push the current branch to origin
+func gitPush(ctx context.Context, branch string) error {
+	_, err := gitClient.Run(ctx, "push", "origin", branch)
 	if err != nil {
 		return fmt.Errorf("Error pushing changes: %v", err)
 	}
 	success("Pushed changes to origin/%s\n", branch)
-
 	return nil
 }
 
+// The spinner drawn while a run works. Prompts pause it, since it redraws its
+// line every 100ms and would write over the question.
+var spinner struct {
+	mu      sync.Mutex
+	running bool
+	paused  bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Start drawing the spinner until stopSpinner is called
+func startSpinner() {
+	spinner.mu.Lock()
+	defer spinner.mu.Unlock()
+	spinner.running, spinner.paused = true, false
+	spinner.done, spinner.stopped = make(chan struct{}), make(chan struct{})
+	go showLoadingIndicator(spinner.done, spinner.stopped)
+}
+
+func stopSpinner() {
+	spinner.mu.Lock()
+	if !spinner.running {
+		spinner.mu.Unlock()
+		return
+	}
+	spinner.running = false
+	close(spinner.done)
+	stopped := spinner.stopped
+	spinner.mu.Unlock()
+	<-stopped
+}
+
+// Stop drawing the spinner and clear its line; call the returned function to resume it
+func pauseSpinner() func() {
+	spinner.mu.Lock()
+	defer spinner.mu.Unlock()
+	if !spinner.running || spinner.paused {
+		return func() {}
+	}
+	spinner.paused = true
+	fmt.Print("\r            \r")
+	return func() {
+		spinner.mu.Lock()
+		spinner.paused = false
+		spinner.mu.Unlock()
+	}
+}
+
 // Show a loading indicator
-func showLoadingIndicator(done chan bool) {
+func showLoadingIndicator(done, stopped chan struct{}) {
+	defer close(stopped)
 	frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
 	i := 0
 	for {
+		spinner.mu.Lock()
+		if !spinner.paused {
+			fmt.Printf("\r%s Working...", frames[i])
+			i = (i + 1) % len(frames)
+		}
+		spinner.mu.Unlock()
+
 		select {
 		case <-done:
 			fmt.Print("\r")
 			return
-		default:
-			fmt.Printf("\r%s Working...", frames[i])
-			i = (i + 1) % len(frames)
-			time.Sleep(100 * time.Millisecond)
+		case <-time.After(100 * time.Millisecond):
 		}
 	}
 }
 
-// Read user input with a timeout
-func readUserInput(timeout time.Duration) (string, error) {
-	inputChan := make(chan string)
-	errChan := make(chan error)
+// Ask the user a question on the terminal. Every prompt goes through here, so
+// the spinner is paused while the question is shown and the answer typed.
+func askUser(ctx context.Context, question string, timeout time.Duration) (string, error) {
+	resume := pauseSpinner()
+	defer resume()
+	info("%s", question)
+	return readUserInput(ctx, timeout)
+}
+
+// A line read from standard input, or why reading stopped
+type inputLine struct {
+	Text string
+	Err  error
+}
+
+// Lines typed on standard input. One goroutine reads them all, so a prompt
+// that timed out doesn't leave a second reader racing the next one.
+var stdinLines = sync.OnceValue(func() <-chan inputLine {
+	lines := make(chan inputLine)
 	go func() {
-		var userInput string
-		_, err := fmt.Scanln(&userInput)
-		if err != nil {
-			errChan <- err
-			return
+		defer close(lines)
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			text, err := reader.ReadString('\n')
+			if err == io.EOF && text != "" {
+				// A last line without a newline still counts
+				err = nil
+			}
+			lines <- inputLine{Text: strings.TrimRight(text, "\r\n"), Err: err}
+			if err != nil {
+				return
+			}
 		}
-		inputChan <- userInput
 	}()
+	return lines
+})
 
+// Read a whole line of user input, which may be empty or contain spaces. Fails
+// on end of input, on a timeout, or when ctx is cancelled by Ctrl-C.
+func readUserInput(ctx context.Context, timeout time.Duration) (string, error) {
 	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
 	case <-time.After(timeout):
 		return "", fmt.Errorf("Input timed out after %v", timeout)
-	case err := <-errChan:
-		return "", err
-	case input := <-inputChan:
-		return input, nil
+	case line, ok := <-stdinLines():
+		if !ok || line.Err != nil {
+			return "", fmt.Errorf("No more input")
+		}
+		return line.Text, nil
 	}
 }
 
@@ -378,6 +460,29 @@ func findGitExecutable() string {
 	return "git"
 }
 
+// Find a shell to run commands such as the editor with: sh on PATH, or on
+// Windows the one Git for Windows ships with. Returns "" if there is none.
+func findShell() string {
+	if path, err := exec.LookPath("sh"); err == nil {
+		return path
+	}
+	if runtime.GOOS != "windows" {
+		return ""
+	}
+	git := findGitExecutable()
+	if !filepath.IsAbs(git) {
+		return ""
+	}
+	// git.exe is in cmd\ and sh.exe in bin\ and usr\bin\ of the installation
+	root := filepath.Dir(filepath.Dir(git))
+	for _, path := range []string{filepath.Join(root, "bin", "sh.exe"), filepath.Join(root, "usr", "bin", "sh.exe")} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
 // Add error handling utility
 func handleError(err error, message string) {
 	if err != nil {
@@ -386,176 +491,627 @@ func handleError(err error, message string) {
 	}
 }
 
-func main() {
-	info("Starting gitdone...\n")
+// Summarize the parsed changes of a diff for the model, grouped by module
+func summarizeFiles(ctx context.Context, files []*fileChanges, source diffSource, cfg *config) ([]*moduleChanges, string, error) {
+	if err := markGeneratedFiles(ctx, files, source); err != nil {
+		return nil, "", err
+	}
+	if err := markFormattingChanges(ctx, files, source); err != nil {
+		return nil, "", err
+	}
+	if err := markDependencyChanges(ctx, files, source); err != nil {
+		return nil, "", err
+	}
+	modules, err := groupByModule(ctx, files, source, cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	return modules, formatModuleSummary(modules), nil
+}
 
-	// Ensure we're in a git repository
-	if _, err := runCommand("git", "rev-parse", "--git-dir"); err != nil {
-		errorLog("Not in a git repository\n")
-		return
+// Generate a commit message for a summary and apply the repository's scope and
+// ticket rules. Also reports how the message was produced, one of the method constants.
+// The commits in exclude, such as those being rewritten, are never used as examples.
+func draftCommitMessage(ctx context.Context, changeSummary string, modules []*moduleChanges, source diffSource, exclude []string, cfg *config) (string, string, error) {
+	// An exact undo of a recent commit is described the way git revert would
+	reverted, err := findRevertedCommit(ctx, source)
+	if err != nil {
+		warn("Skipping revert detection: %v\n", err)
+	}
+	if reverted != nil {
+		info("The changes undo %s; skipping the model.\n", shortSHA(reverted.SHA))
+		commitMsg, err := addTicketReferences(ctx, revertMessage(reverted), cfg)
+		return commitMsg, methodRevert, err
 	}
 
-	done := make(chan bool)
-	go showLoadingIndicator(done)
+	// Whitespace changes would be read as functional ones
+	if commitMsg, ok := formattingMessage(modules); ok {
+		info("Only whitespace changed; skipping the model.\n")
+		commitMsg, err = addTicketReferences(ctx, applyScopes(commitMsg, commitScopes(modules, cfg)), cfg)
+		return commitMsg, methodFormatting, err
+	}
 
-	// Create error channel for goroutine error handling
-	errChan := make(chan error, 1)
+	// A pure dependency bump is fully described without the model
+	if commitMsg, ok := dependencyBumpMessage(modules); ok {
+		info("Only dependency versions changed; skipping the model.\n")
+		commitMsg, err = addTicketReferences(ctx, applyScopes(commitMsg, commitScopes(modules, cfg)), cfg)
+		return commitMsg, methodDependencies, err
+	}
 
-	go func() {
-		if err := addAllChanges(); err != nil {
-			errChan <- err
-			return
+	// Past commits are only examples, so a missing embedding model is not fatal
+	similar, err := findSimilarCommits(ctx, changeSummary, exclude)
+	if err != nil {
+		warn("Skipping similar commit examples: %v\n", err)
+	}
+
+	commitMsg, err := generateCommitMessage(ctx, changeSummary, formatSimilarCommits(similar))
+	if err != nil {
+		return "", "", err
+	}
+
+	commitMsg, err = addTicketReferences(ctx, applyScopes(commitMsg, commitScopes(modules, cfg)), cfg)
+	return commitMsg, methodModel, err
+}
+
+// The commit a run builds on, which is left out of the examples: it is
+// usually the work in progress this commit continues.
+func excludeHead(snapshot *repoSnapshot) []string {
+	if snapshot.Head == "" {
+		return nil
+	}
+	return []string{snapshot.Head}
+}
+
+// Stage everything, generate a message, then commit and push, filling in result as it goes.
+// If the run is cancelled, a phase times out, or a hook or the review stops it, the index is restored.
+func runGitdone(ctx context.Context, cfg *config, opts commitOptions, result *runResult) (err error) {
+	snapshot, err := takeSnapshot(ctx)
+	if err != nil {
+		return failPhase(exitError, err)
+	}
+	if cfg, err = withTrustedHooks(ctx, cfg); err != nil {
+		return failPhase(exitError, err)
+	}
+	defer func() {
+		if wasCancelled(err) {
+			rollbackCancelledRun(snapshot, result)
+		} else if code := exitCode(err); code == exitHook || code == exitReview {
+			rollbackVetoedRun(snapshot, result)
 		}
+		journalRun(snapshot, result)
+	}()
 
-		diff, err := getGitDiff()
-		if err != nil {
-			errChan <- err
-			return
+	// Pushes queued while offline go out, the model loads and new commits are
+	// embedded for the history index, while git stages and diffs. None of them
+	// holds up the run, and none failing stops it.
+	flush := startBackgroundPhase(ctx, "flush", phaseTimeouts.Push, flushPushQueueQuietly)
+	defer flush.wait(result)
+	warmup := startBackgroundPhase(ctx, "warmup", phaseTimeouts.Generate, func(ctx context.Context) {
+		model.Warm(ctx)
+	})
+	defer warmup.stop(result)
+	indexing := startBackgroundPhase(ctx, "index", phaseTimeouts.Generate, refreshHistoryIndexQuietly)
+	defer indexing.stop(result)
+
+	op, err := detectInProgress(ctx)
+	if err != nil {
+		return failPhase(exitError, err)
+	}
+	var conflicts []string
+	if op != nil {
+		if conflicts, err = checkConflicts(ctx, op); err != nil {
+			return failPhase(exitConflict, err)
 		}
+		info("Completing the %s in progress.\n", op.Kind)
+	}
 
-		if diff == "" {
-			errChan <- fmt.Errorf("no changes to commit")
-			return
+	var p *phase
+	if opts.Interactive {
+		p = startPhase(ctx, result, "stage", 0)
+		err = p.end(exitError, selectChanges(p.ctx, askSelection))
+	} else {
+		p = startPhase(ctx, result, "stage", phaseTimeouts.Commit)
+		err = p.end(exitError, addAllChanges(p.ctx))
+	}
+	if err != nil {
+		return err
+	}
+	result.Steps = append(result.Steps, "staged changes")
+
+	p = startPhase(ctx, result, "guard", phaseTimeouts.Commit)
+	if err = p.end(exitError, guardLargeFiles(p.ctx, cfg)); err != nil {
+		return err
+	}
+
+	p = startPhase(ctx, result, "diff", phaseTimeouts.Commit)
+	files, err := readStagedChanges(p.ctx)
+	if err = p.end(exitError, err); err != nil {
+		return err
+	}
+	// A merge resolved in favour of our side still needs its merge commit
+	if len(files) == 0 && (op == nil || op.Kind != opMerge) {
+		return errNoChanges
+	}
+
+	for _, file := range files {
+		result.Files = append(result.Files, file.Path)
+	}
+	if opts.Review {
+		p = startPhase(ctx, result, "review", phaseTimeouts.Generate)
+		if err = p.end(exitGenerate, reviewStaged(p.ctx, result)); err != nil {
+			return err
 		}
+		result.Steps = append(result.Steps, "reviewed changes")
+	}
+	if _, err = runHookPhase(ctx, result, cfg, hookInput{Hook: hookPreSummary}); err != nil {
+		return err
+	}
 
-		changeSummary := generateChangeSummary(diff)
-		commitMsg, err := generateCommitMessage(changeSummary)
-		if err != nil {
-			errChan <- err
-			return
+	p = startPhase(ctx, result, "summarize", phaseTimeouts.Generate)
+	modules, changeSummary, err := summarizeFiles(p.ctx, files, stagedChanges, cfg)
+	if err = p.end(exitError, err); err != nil {
+		return err
+	}
+	result.Summary = changeSummary
+
+	p = startPhase(ctx, result, "generate", phaseTimeouts.Generate)
+	var commitMsg, method string
+	if op != nil && op.Kind != opRebase {
+		// Git has already written the message; only conflict resolutions need describing
+		method = methodCompletion
+		commitMsg, err = completionMessage(p.ctx, op, conflicts)
+	} else {
+		commitMsg, method, err = draftCommitMessage(p.ctx, changeSummary, modules, stagedChanges, excludeHead(snapshot), cfg)
+	}
+	if err = p.end(exitGenerate, err); err != nil {
+		return err
+	}
+
+	trailers, err := commitTrailers(ctx, opts, cfg)
+	if err != nil {
+		return failPhase(exitCommit, err)
+	}
+	commitMsg = appendTrailers(commitMsg, trailers)
+	commitMsg, err = runHookPhase(ctx, result, cfg, hookInput{Hook: hookPostGenerate, Summary: changeSummary, Message: commitMsg})
+	if err != nil {
+		return err
+	}
+	result.Message = commitMsg
+	result.Steps = append(result.Steps, "generated message")
+
+	commitMsg, err = runHookPhase(ctx, result, cfg, hookInput{Hook: hookPreCommit, Summary: changeSummary, Message: commitMsg})
+	if err != nil {
+		return err
+	}
+	result.Message = commitMsg
+
+	edited := false
+	if opts.Edit {
+		// Waits on the user, so it has no time limit
+		p = startPhase(ctx, result, "edit", 0)
+		var editedMsg string
+		editedMsg, err = editMessage(p.ctx, commitMsg)
+		if err = p.end(exitCommit, err); err != nil {
+			return err
 		}
+		edited = editedMsg != commitMsg
+		commitMsg = editedMsg
+		result.Message = commitMsg
+	}
 
-		if err := gitCommitAndPush(commitMsg); err != nil {
-			errChan <- err
-			return
+	p = startPhase(ctx, result, "commit", phaseTimeouts.Commit)
+	result.Commit, err = gitCommit(p.ctx, commitMsg, opts)
+	if result.Commit != "" {
+		result.Steps = append(result.Steps, "committed "+shortSHA(result.Commit))
+	}
+	if err = p.end(exitCommit, err); err != nil {
+		return err
+	}
+	note := newProvenance("commit", method, time.Duration(result.Timings["generate"])*time.Millisecond)
+	note.Edited = edited
+	recordProvenanceQuietly(ctx, result.Commit, note)
+
+	if op != nil && op.Kind == opRebase {
+		// A rebased branch needs a force push, which is left to the user
+		result.Branch = op.Branch
+		p = startPhase(ctx, result, "continue", phaseTimeouts.Commit)
+		if err = p.end(exitConflict, continueRebase(p.ctx)); err != nil {
+			return err
 		}
+		result.Steps = append(result.Steps, "continued rebase")
+		success("Continued the rebase. Push %s with --force-with-lease once it is done.\n", op.Branch)
+		return nil
+	}
 
-		errChan <- nil
-	}()
+	result.Branch, err = currentBranch(ctx)
+	if err != nil {
+		return failPhase(exitPush, err)
+	}
 
-	// Wait for either an error or completion
-	select {
-	case err := <-errChan:
-		done <- true
-		if err != nil {
-			if err.Error() == "no changes to commit" {
-				warn("No changes to commit.\n")
+	// Queued pushes go out before this one, as they would have without the queue
+	flush.wait(result)
+	p = startPhase(ctx, result, "push", phaseTimeouts.Push)
+	err = p.end(exitPush, gitPush(p.ctx, result.Branch))
+	if err != nil {
+		result.PushError = err.Error()
+		if isOfflineError(err) {
+			if queueErr := queuePush(ctx, result.Branch, result.Commit, err); queueErr != nil {
+				warn("Could not queue the push: %v\n", queueErr)
 			} else {
-				errorLog("Error: %v\n", err)
+				result.Queued = true
+				warn("The remote is unreachable; queued the push of %s. It will be retried on the next run, or with gitdone push --pending.\n", result.Branch)
 			}
-			return
 		}
-		success("\ngitdone completed successfully.\n")
-	case <-time.After(timeout):
-		done <- true
-		errorLog("Operation timed out\n")
-		return
+		return err
 	}
+	result.Pushed = true
+	result.Steps = append(result.Steps, "pushed to origin/"+result.Branch)
+
+	// The push already happened, so a failing hook can only be reported
+	postPush := hookInput{Hook: hookPostPush, Summary: changeSummary, Message: commitMsg, Branch: result.Branch, Commit: result.Commit}
+	if _, err := runHookPhase(ctx, result, cfg, postPush); err != nil {
+		warn("%v\n", err)
+	}
+
+	return nil
 }
 
-// Optimize generateChangeSummary for large diffs
-func generateChangeSummary(diff string) string {
-	var summary strings.Builder
-	summary.Grow(len(diff) / 2)
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := getCommands()[os.Args[1]]; ok {
+			os.Exit(cmd.Action(os.Args[2:]))
+		}
+	}
 
-	if len(diff) > maxDiffSize {
-		lines := strings.Split(diff, "\n")
-		var truncatedLines []string
+	var opts commitOptions
+	var pairs, output string
+	var yes bool
+	flag.Usage = printUsage
+	signingFlags(flag.CommandLine, &opts)
+	flag.BoolVar(&opts.Signoff, "s", false, "Add a Signed-off-by trailer")
+	flag.BoolVar(&opts.Interactive, "i", false, "Choose the files and hunks to commit")
+	flag.BoolVar(&opts.Review, "review", false, "Review the changes first and stop on high-severity findings")
+	flag.BoolVar(&opts.Edit, "edit", false, "Edit the generated message in your git editor before committing")
+	flag.StringVar(&pairs, "with", "", "Comma-separated pair partner aliases to add as Co-authored-by")
+	flag.StringVar(&output, "output", "text", "Output format: text or json")
+	flag.BoolVar(&yes, "yes", false, "Never prompt and never show the spinner")
+	flag.BoolVar(&showTimings, "timings", false, "Print how long each phase took")
+	flag.DurationVar(&phaseTimeouts.Generate, "generate-timeout", phaseTimeouts.Generate, "Time limit for summarizing and generating the message")
+	flag.DurationVar(&phaseTimeouts.Commit, "commit-timeout", phaseTimeouts.Commit, "Time limit for staging and committing")
+	flag.DurationVar(&phaseTimeouts.Push, "push-timeout", phaseTimeouts.Push, "Time limit for pushing")
+	flag.Parse()
+
+	if err := configureOutput(output, yes); err != nil {
+		errorLog("%v\n", err)
+		os.Exit(exitUsage)
+	}
+	if err := checkSigningFlags(opts); err != nil {
+		errorLog("%v\n", err)
+		os.Exit(exitUsage)
+	}
+	if opts.Interactive && yes {
+		errorLog("-i and --yes cannot be used together\n")
+		os.Exit(exitUsage)
+	}
+	if opts.Edit && yes {
+		errorLog("--edit and --yes cannot be used together\n")
+		os.Exit(exitUsage)
+	}
+	if pairs != "" {
+		opts.Pairs = strings.Split(pairs, ",")
+	}
 
-		var fileHeader string
-		changesInCurrentFile := 0
+	ctx, stop := interruptContext()
+	code := run(ctx, opts, newRunResult())
+	stop()
+	os.Exit(code)
+}
 
-		for _, line := range lines {
-			if strings.HasPrefix(line, "diff --git") {
-				fileHeader = line
-				changesInCurrentFile = 0
-				truncatedLines = append(truncatedLines, fileHeader)
-				continue
-			}
+// Run gitdone with a spinner until it finishes or is interrupted, returning the exit code
+func run(ctx context.Context, opts commitOptions, result *runResult) int {
+	info("Starting gitdone...\n")
+	stopTotal := result.time("total")
 
-			if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
-				if changesInCurrentFile < 10 {
-					truncatedLines = append(truncatedLines, line)
-					changesInCurrentFile++
-				}
-			}
-		}
+	// Ensure we're in a git repository
+	cfg, err := openRepo(ctx)
+	if err != nil {
+		return finishRun(result, failPhase(exitNotRepo, err))
+	}
 
-		diff = strings.Join(truncatedLines, "\n")
+	// The spinner would garble JSON output and script logs
+	if !assumeYes && !jsonOutput && !opts.Interactive && !opts.Edit {
+		startSpinner()
 	}
 
-	// Extract file changes more efficiently
-	files := make(map[string]bool)
-	changes := make(map[string][]string, 10) // Pre-allocate with reasonable size
+	err = runGitdone(ctx, cfg, opts, result)
+	stopSpinner()
+	stopTotal()
+	return finishRun(result, err)
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(diff))
-	var currentFile string
+// Changes found in one file of a diff
+type fileChanges struct {
+	Path    string
+	Changes []string
+	// Lines added and removed, as git diff --numstat counts them
+	Added, Removed int
+	// Enclosing functions from the hunk headers, in diff order
+	Functions []string
+	// Whole-file operations such as renames, deletions, mode changes and submodule updates
+	Operations []string
+	Deleted    bool
+	// Why the file counts as generated ("lock file", "generated code", ...), empty if it doesn't
+	Generated string
+	// Dependency changes in a manifest, and whether nothing else in it changed
+	Dependencies     []dependencyChange
+	OnlyDependencies bool
+	// Only whitespace or blank lines changed
+	Reformatted bool
+}
 
-	// Use a buffer pool for large lines
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+// Parse a diff into the important changes of each file, in diff order
+func parseDiffChanges(diff string) []*fileChanges {
+	// Reading from a string can't fail
+	files, _ := readDiffChanges(strings.NewReader(diff))
+	return files
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+// Read a diff line by line into the important changes of each file, in diff
+// order. Memory stays bounded however large the diff is: long lines are cut
+// short, and once maxDiffSize bytes of changes are kept, each file keeps
+// only its first few changed lines.
+func readDiffChanges(r io.Reader) ([]*fileChanges, error) {
+	truncate := false
+	kept := 0 // Bytes of change lines kept so far
+	changedLines := 0
+
+	var files []*fileChanges
+	var current *fileChanges
+	var header diffHeader
+
+	// Describe what the header lines said once a file's diff is complete
+	finishFile := func() {
+		if current == nil {
+			return
+		}
+		current.Operations = header.operations(current.Path)
+		current.Deleted = header.Deleted
+		if header.Deleted {
+			// The removed content of a deleted file says nothing new
+			current.Changes = nil
+		}
+	}
 
+	err := readDiffLines(r, func(line string) {
 		if strings.HasPrefix(line, "diff --git") {
 			parts := strings.SplitN(line, " ", 4) // Limit split operations
 			if len(parts) >= 3 {
-				currentFile = strings.TrimPrefix(parts[2], "a/")
-				files[currentFile] = true
-				if _, exists := changes[currentFile]; !exists {
-					changes[currentFile] = make([]string, 0, 10)
+				finishFile()
+				// The b/ side is the path after a rename
+				path := strings.TrimPrefix(parts[2], "a/")
+				if len(parts) == 4 {
+					path = strings.TrimPrefix(parts[3], "b/")
+				}
+				current = &fileChanges{
+					Path:    path,
+					Changes: make([]string, 0, 10),
 				}
+				header = diffHeader{}
+				files = append(files, current)
+				changedLines = 0
 			}
-			continue
+			return
+		}
+		if current != nil && header.parse(line) {
+			return
+		}
+		if strings.HasPrefix(line, "@@") {
+			header.Hunks = true
+			if current != nil {
+				current.addFunction(hunkContext(line))
+			}
+			return
 		}
 
-		// Optimize change detection
-		if len(line) > 0 {
-			switch line[0] {
-			case '+':
-				if !strings.HasPrefix(line, "+++") {
-					line = strings.TrimPrefix(line, "+")
-					if isImportantChange(line) {
-						changes[currentFile] = append(changes[currentFile], "Added: "+line)
-					}
-				}
-			case '-':
-				if !strings.HasPrefix(line, "---") {
-					line = strings.TrimPrefix(line, "-")
-					if isImportantChange(line) {
-						changes[currentFile] = append(changes[currentFile], "Removed: "+line)
-					}
+		// Only note that binary files changed, never their content
+		if current != nil && strings.HasPrefix(line, "Binary files ") {
+			header.Hunks = true
+			current.Changes = append(current.Changes, "Binary file changed")
+			return
+		}
+
+		if current == nil || len(line) == 0 || (line[0] != '+' && line[0] != '-') {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			return
+		case line[0] == '+':
+			current.Added++
+		default:
+			current.Removed++
+		}
+		if truncate && changedLines >= 10 {
+			return
+		}
+		changedLines++
+
+		if !isImportantChange(line[1:]) {
+			return
+		}
+		if line[0] == '+' {
+			current.Changes = append(current.Changes, "Added: "+line[1:])
+		} else {
+			current.Changes = append(current.Changes, "Removed: "+line[1:])
+		}
+		kept += len(line)
+		if !truncate && kept > maxDiffSize {
+			// The diff turned out large: trim what was kept to match
+			truncate = true
+			for _, file := range files {
+				if len(file.Changes) > 10 {
+					file.Changes = append([]string(nil), file.Changes[:10]...)
 				}
 			}
 		}
+	})
+	finishFile()
+	return files, err
+}
+
+// Longest diff line kept; the rest of a longer line, such as minified code, is skipped
+const maxDiffLineLength = 4096
+
+// Call fn with each line of r, without the newline
+func readDiffLines(r io.Reader, fn func(line string)) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading diff: %v", err)
+		}
+		line := string(chunk[:min(len(chunk), maxDiffLineLength)])
+		for isPrefix {
+			if _, isPrefix, err = reader.ReadLine(); err != nil && err != io.EOF {
+				return fmt.Errorf("Error reading diff: %v", err)
+			}
+		}
+		fn(line)
 	}
+}
+
+// Format per-file changes as the summary sent to the model
+func formatChangeSummary(files []*fileChanges) string {
+	return formatChangeSummaryWithin(files, maxSummarySize)
+}
+
+// Get the function named in a hunk header such as "@@ -1,2 +1,3 @@ func main() {"
+func hunkContext(line string) string {
+	parts := strings.SplitN(line, "@@", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(parts[2]), "{"))
+}
+
+// Record an enclosing function once, up to maxHunkContexts
+func (f *fileChanges) addFunction(name string) {
+	if name == "" || len(f.Functions) >= maxHunkContexts {
+		return
+	}
+	for _, existing := range f.Functions {
+		if existing == name {
+			return
+		}
+	}
+	f.Functions = append(f.Functions, name)
+}
+
+// Format the "In path (+a -r):" heading of a file's section
+func (f *fileChanges) heading() string {
+	if f.Added == 0 && f.Removed == 0 {
+		return "\nIn " + f.Path + ":\n"
+	}
+	return fmt.Sprintf("\nIn %s (+%d -%d):\n", f.Path, f.Added, f.Removed)
+}
+
+// Format the summary, keeping it near budget bytes. When it would be larger,
+// files are listed largest change first and the smaller ones lose their details.
+func formatChangeSummaryWithin(files []*fileChanges, budget int) string {
+	var summary strings.Builder
 
 	// Build summary efficiently
 	if len(files) > 0 {
 		summary.WriteString("Files changed:\n")
-		for file := range files {
+		for _, file := range files {
 			summary.WriteString("* ")
-			summary.WriteString(file)
+			summary.WriteString(file.Path)
 			summary.WriteByte('\n')
 		}
 		summary.WriteByte('\n')
 	}
 
 	summary.WriteString("Technical changes:\n")
-	for file, fileChanges := range changes {
-		if len(fileChanges) > 0 {
-			summary.WriteString("\nIn ")
-			summary.WriteString(file)
-			summary.WriteString(":\n")
-			for _, change := range fileChanges {
-				summary.WriteString("* ")
-				summary.WriteString(change)
+	var edited []*fileChanges
+	for _, file := range files {
+		if len(file.Operations) > 0 {
+			summary.WriteByte('\n')
+			for _, operation := range file.Operations {
+				summary.WriteString(operation)
 				summary.WriteByte('\n')
 			}
 		}
+		if file.Deleted {
+			continue
+		}
+		if file.Generated != "" {
+			summary.WriteString("\nRegenerated ")
+			summary.WriteString(file.Path)
+			summary.WriteString(" (")
+			summary.WriteString(file.Generated)
+			summary.WriteString(")\n")
+			continue
+		}
+		if file.Reformatted {
+			summary.WriteString("\nReformatted ")
+			summary.WriteString(file.Path)
+			summary.WriteString(" (whitespace only)\n")
+			continue
+		}
+		if len(file.Changes) > 0 || file.Added > 0 || file.Removed > 0 {
+			edited = append(edited, file)
+		}
 	}
 
+	// Each file's section as lines: heading, enclosing functions, then changes
+	sections := make([][]string, len(edited))
+	total := summary.Len()
+	for i, file := range edited {
+		lines := []string{file.heading()}
+		if len(file.Functions) > 0 {
+			lines = append(lines, "* Functions: "+strings.Join(file.Functions, "; ")+"\n")
+		}
+		for _, change := range file.Changes {
+			lines = append(lines, "* "+change+"\n")
+		}
+		sections[i] = lines
+		for _, line := range lines {
+			total += len(line)
+		}
+	}
+
+	order := make([]int, len(edited))
+	for i := range order {
+		order[i] = i
+	}
+	tight := total > budget
+	room := 0 // Bytes left for details when tight
+	if tight {
+		// Over budget: the biggest changes matter most to the message, and
+		// every file keeps at least its heading
+		sort.SliceStable(order, func(a, b int) bool {
+			return edited[order[a]].Added+edited[order[a]].Removed > edited[order[b]].Added+edited[order[b]].Removed
+		})
+		room = budget - summary.Len()
+		for _, i := range order {
+			room -= len(sections[i][0])
+		}
+	}
+	for _, i := range order {
+		summary.WriteString(sections[i][0])
+		for _, line := range sections[i][1:] {
+			if tight {
+				if len(line) > room {
+					break
+				}
+				room -= len(line)
+			}
+			summary.WriteString(line)
+		}
+	}
 	return summary.String()
 }
 
@@ -580,26 +1136,3 @@ func isImportantChange(line string) bool {
 	}
 	return false
 }
-
-// Extract modified files from diff
-func extractModifiedFiles(diff string) []string {
-	modifiedFiles := make(map[string]bool)
-	scanner := bufio.NewScanner(strings.NewReader(diff))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "diff --git ") {
-			parts := strings.Split(line, " ")
-			if len(parts) >= 4 {
-				aFile := parts[2]
-				// Remove 'a/' prefix
-				aFile = strings.TrimPrefix(aFile, "a/")
-				modifiedFiles[aFile] = true
-			}
-		}
-	}
-	fileList := make([]string, 0, len(modifiedFiles))
-	for file := range modifiedFiles {
-		fileList = append(fileList, file)
-	}
-	return fileList
-}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMaxFileSize   = 10 << 20 // 10 MiB
+	defaultMaxBinarySize = 1 << 20  // 1 MiB
+)
+
+// A staged file that is too large or binary to commit without asking
+type guardedFile struct {
+	Path   string
+	Size   int64
+	Binary bool
+}
+
+func (f guardedFile) describe() string {
+	kind := "file"
+	if f.Binary {
+		kind = "binary file"
+	}
+	return fmt.Sprintf("%s %s (%s)", kind, f.Path, formatSize(f.Size))
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
+
+// Find staged files over the size thresholds that Git LFS doesn't already track
+func findGuardedFiles(ctx context.Context, cfg *config) ([]guardedFile, error) {
+	numstat, err := gitClient.Run(ctx, "diff", "--cached", "--numstat", "--no-renames", "--diff-filter=AM")
+	if err != nil {
+		return nil, fmt.Errorf("Error listing staged files: %v", err)
+	}
+
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	maxFileSize, maxBinarySize := cfg.MaxFileSize, cfg.MaxBinarySize
+	if maxFileSize <= 0 {
+		maxFileSize = defaultMaxFileSize
+	}
+	if maxBinarySize <= 0 {
+		maxBinarySize = defaultMaxBinarySize
+	}
+
+	var guarded []guardedFile
+	for _, line := range strings.Split(strings.TrimSpace(numstat), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		file := guardedFile{Path: fields[2], Binary: fields[0] == "-" && fields[1] == "-"}
+
+		size, err := gitClient.Run(ctx, "cat-file", "-s", ":"+file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading size of %s: %v", file.Path, err)
+		}
+		if file.Size, err = strconv.ParseInt(strings.TrimSpace(size), 10, 64); err != nil {
+			return nil, fmt.Errorf("Unexpected size for %s: %q", file.Path, size)
+		}
+
+		limit := maxFileSize
+		if file.Binary {
+			limit = maxBinarySize
+		}
+		if file.Size <= limit {
+			continue
+		}
+
+		tracked, err := isLFSTracked(ctx, root, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !tracked {
+			guarded = append(guarded, file)
+		}
+	}
+	return guarded, nil
+}
+
+// Check whether .gitattributes routes a path through Git LFS
+func isLFSTracked(ctx context.Context, root, path string) (bool, error) {
+	attr, err := gitClient.Run(ctx, "-C", root, "check-attr", "filter", "--", path)
+	if err != nil {
+		return false, fmt.Errorf("Error reading attributes of %s: %v", path, err)
+	}
+	return strings.HasSuffix(strings.TrimSpace(attr), ": filter: lfs"), nil
+}
+
+// Take a file back out of the index, leaving the working tree alone
+func unstageFile(ctx context.Context, path string) error {
+	var err error
+	if _, headErr := gitClient.Run(ctx, "rev-parse", "--verify", "-q", "HEAD"); headErr != nil {
+		_, err = gitClient.Run(ctx, "rm", "--cached", "-q", "--", ":(top)"+path)
+	} else {
+		_, err = gitClient.Run(ctx, "reset", "-q", "--", ":(top)"+path)
+	}
+	if err != nil {
+		return fmt.Errorf("Error unstaging %s: %v", path, err)
+	}
+	return nil
+}
+
+// Unstage a file and add it to the repository's .gitignore
+func ignoreFile(ctx context.Context, path string) error {
+	if err := unstageFile(ctx, path); err != nil {
+		return err
+	}
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return err
+	}
+
+	gitignore := filepath.Join(root, ".gitignore")
+	existing, err := os.ReadFile(gitignore)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Error reading .gitignore: %v", err)
+	}
+	entry := "/" + path + "\n"
+	if len(existing) > 0 && !strings.HasSuffix(string(existing), "\n") {
+		entry = "\n" + entry
+	}
+	if err := os.WriteFile(gitignore, append(existing, entry...), 0644); err != nil {
+		return fmt.Errorf("Error writing .gitignore: %v", err)
+	}
+	if _, err := gitClient.Run(ctx, "add", "--", ":(top).gitignore"); err != nil {
+		return fmt.Errorf("Error staging .gitignore: %v", err)
+	}
+	return nil
+}
+
+// Track a file with Git LFS and restage it as an LFS pointer
+func trackWithLFS(ctx context.Context, path string) error {
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := gitClient.Run(ctx, "-C", root, "lfs", "track", "--filename", "--", path); err != nil {
+		return fmt.Errorf("Error tracking %s with Git LFS (is git-lfs installed?): %v", path, err)
+	}
+	if err := unstageFile(ctx, path); err != nil {
+		return err
+	}
+	if _, err := gitClient.Run(ctx, "add", "--", ":(top).gitattributes", ":(top)"+path); err != nil {
+		return fmt.Errorf("Error staging %s through LFS: %v", path, err)
+	}
+	return nil
+}
+
+// Ask what to do with each large or binary staged file. Under --yes they are
+// unstaged, since committing them is hard to take back.
+func guardLargeFiles(ctx context.Context, cfg *config) error {
+	guarded, err := findGuardedFiles(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range guarded {
+		warn("Staged %s is over the size limit and not tracked by Git LFS.\n", file.describe())
+
+		choice := "u"
+		if !assumeYes {
+			answer, err := askUser(ctx, "[u]nstage, add to .git[i]gnore, track with [l]fs, or [k]eep? ", userTimeout)
+			if err != nil {
+				fmt.Println()
+			} else {
+				choice = strings.ToLower(strings.TrimSpace(answer))
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(choice, "k"):
+			info("Keeping %s staged.\n", file.Path)
+		case strings.HasPrefix(choice, "i"):
+			if err := ignoreFile(ctx, file.Path); err != nil {
+				return err
+			}
+			success("Unstaged %s and added it to .gitignore.\n", file.Path)
+		case strings.HasPrefix(choice, "l"):
+			if err := trackWithLFS(ctx, file.Path); err != nil {
+				return err
+			}
+			success("Tracking %s with Git LFS.\n", file.Path)
+		default:
+			if err := unstageFile(ctx, file.Path); err != nil {
+				return err
+			}
+			success("Unstaged %s.\n", file.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGuardUnstagesLargeAndBinaryFiles(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added small helper")
+
+	previous := assumeYes
+	assumeYes = true
+	t.Cleanup(func() { assumeYes = previous })
+
+	repo.write(".gitdone.json", `{"maxFileSize": 1000, "maxBinarySize": 100}`)
+	repo.git("add", ".gitdone.json")
+	repo.git("commit", "-m", "Added gitdone config")
+
+	repo.write("small.go", "package main\n\nfunc helper() {}\n")
+	repo.write("dataset.csv", strings.Repeat("1,2,3\n", 500))
+	repo.write("model.bin", "\x00\x01\x02"+strings.Repeat("\x00", 200))
+	repo.write("icon.bin", "\x00\x01")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	if got := repo.git("diff-tree", "--no-commit-id", "--name-only", "-r", "HEAD"); got != "icon.bin\nsmall.go" {
+		t.Errorf("committed files = %q, want only icon.bin and small.go", got)
+	}
+	if got := repo.git("status", "--porcelain"); got != "?? dataset.csv\n?? model.bin" {
+		t.Errorf("status = %q, want the large files left untracked", got)
+	}
+	if prompt := server.receivedPrompts()[0]; strings.Contains(prompt, "\x01") || !strings.Contains(prompt, "Binary file changed") {
+		t.Errorf("prompt should note icon.bin without its content:\n%q", prompt)
+	}
+}
+
+func TestGuardSkipsLFSTrackedFiles(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write(".gitattributes", "*.bin filter=lfs diff=lfs merge=lfs -text\n")
+	repo.write("model.bin", strings.Repeat("\x00", 2<<20))
+	repo.write("huge.dat", strings.Repeat("\x00", 2<<20))
+	repo.git("add", "model.bin", "huge.dat")
+
+	guarded, err := findGuardedFiles(context.Background(), defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(guarded) != 1 || guarded[0].Path != "huge.dat" || !guarded[0].Binary {
+		t.Errorf("guarded = %+v, want only huge.dat", guarded)
+	}
+}
+
+func TestGuardFromSubdirectory(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.write(".gitattributes", "*.bin filter=lfs diff=lfs merge=lfs -text\n")
+	repo.write("sub/model.bin", strings.Repeat("\x00", 2<<20))
+	repo.write("sub/huge.dat", strings.Repeat("\x00", 2<<20))
+	repo.git("add", ".")
+
+	// git prints root-relative paths, which gitdone must not resolve against the subdirectory
+	gitClient = execGit{Dir: filepath.Join(repo.Dir, "sub")}
+
+	guarded, err := findGuardedFiles(context.Background(), defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(guarded) != 1 || guarded[0].Path != "sub/huge.dat" {
+		t.Fatalf("guarded = %+v, want only sub/huge.dat", guarded)
+	}
+	if err := ignoreFile(context.Background(), "sub/huge.dat"); err != nil {
+		t.Fatalf("ignoreFile: %v", err)
+	}
+	if got := repo.git("diff", "--cached", "--name-only"); got != ".gitattributes\n.gitignore\nsub/model.bin" {
+		t.Errorf("staged after ignoring = %q", got)
+	}
+	if got := repo.git("show", ":.gitignore"); got != "/sub/huge.dat" {
+		t.Errorf(".gitignore = %q", got)
+	}
+}
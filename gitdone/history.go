@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	ollamaEmbeddingsURL   = "http://localhost:11434/api/embeddings"
+	embeddingModel        = "nomic-embed-text"
+	maxHistoryCommits     = 100  // How far back the index reaches on its first build
+	maxHistorySummarySize = 8000 // Summary size embedded for each past commit
+	historyBatchSize      = 10   // Commits embedded between saves of the index
+	similarCommitCount    = 3
+	minSimilarity         = 0.5
+)
+
+// A past commit stored in the similarity index
+type historyEntry struct {
+	SHA     string    `json:"sha"`
+	Message string    `json:"message"`
+	Files   []string  `json:"files"`
+	Vector  []float64 `json:"vector"`
+}
+
+// Per-repo index of embedded commits, kept under the git directory
+type historyIndex struct {
+	Model   string         `json:"model"`
+	Commits []historyEntry `json:"commits"`
+}
+
+// A past commit that resembles the change being committed
+type similarCommit struct {
+	Message string
+	Files   []string
+	Score   float64
+}
+
+// Get the directory gitdone keeps per-repo state in, creating it if needed
+func gitdoneDataDir(ctx context.Context) (string, error) {
+	gitDir, err := gitClient.Run(ctx, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return "", fmt.Errorf("Error locating git directory: %v", err)
+	}
+	dir := filepath.Join(strings.TrimSpace(gitDir), "gitdone")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("Error creating %s: %v", dir, err)
+	}
+	return dir, nil
+}
+
+func historyIndexPath(ctx context.Context) (string, error) {
+	dir, err := gitdoneDataDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.json"), nil
+}
+
+// Load the history index, starting fresh if it is missing or built with another model
+func loadHistoryIndex(ctx context.Context) (*historyIndex, error) {
+	path, err := historyIndexPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &historyIndex{Model: embeddingModel}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, fmt.Errorf("Error reading history index: %v", err)
+	}
+	if err := json.Unmarshal(data, index); err != nil || index.Model != embeddingModel {
+		return &historyIndex{Model: embeddingModel}, nil
+	}
+	return index, nil
+}
+
+// Save the index through a temporary file, so a lookup never reads a half-written one
+func saveHistoryIndex(ctx context.Context, index *historyIndex) error {
+	path, err := historyIndexPath(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("Error marshaling history index: %v", err)
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return fmt.Errorf("Error writing history index: %v", err)
+	}
+	return os.Rename(temp, path)
+}
+
+// Text embedded for a commit: its message followed by a summary of its diff
+func historyDocument(message, changeSummary string) string {
+	return "Commit message:\n" + message + "\n\n" + changeSummary
+}
+
+// Add the recent commits missing from the index, newest first. The index is
+// saved after every batch, so a build cut short by a deadline or a failed
+// embedding keeps its progress and the next run carries on from there.
+func updateHistoryIndex(ctx context.Context, index *historyIndex) error {
+	revs, err := gitClient.Run(ctx, "rev-list", "--no-merges", fmt.Sprintf("--max-count=%d", maxHistoryCommits), "HEAD")
+	if err != nil {
+		// A repository without commits has no history to index
+		return nil
+	}
+
+	known := make(map[string]bool, len(index.Commits))
+	for _, entry := range index.Commits {
+		known[entry.SHA] = true
+	}
+
+	var added []historyEntry
+	save := func() error {
+		if len(added) == 0 {
+			return nil
+		}
+		index.Commits = append(index.Commits, added...)
+		added = nil
+		// Progress is saved even once the deadline has passed
+		return saveHistoryIndex(context.WithoutCancel(ctx), index)
+	}
+	for _, sha := range strings.Fields(revs) {
+		if known[sha] {
+			continue
+		}
+
+		message, err := gitClient.Run(ctx, "log", "-1", "--format=%B", sha)
+		if err != nil {
+			save()
+			return err
+		}
+		files, err := streamDiffChanges(ctx, "show", "--format=", sha)
+		if err != nil {
+			save()
+			return err
+		}
+		paths := make([]string, 0, len(files))
+		for _, file := range files {
+			paths = append(paths, file.Path)
+		}
+
+		message = strings.TrimSpace(message)
+		vector, err := model.Embed(ctx, historyDocument(message, formatChangeSummaryWithin(files, maxHistorySummarySize)))
+		if err != nil {
+			save()
+			return err
+		}
+		added = append(added, historyEntry{
+			SHA:     sha,
+			Message: message,
+			Files:   paths,
+			Vector:  vector,
+		})
+		if len(added) == historyBatchSize {
+			if err := save(); err != nil {
+				return err
+			}
+		}
+	}
+	return save()
+}
+
+// Bring the index up to date, warning instead of failing since examples are optional.
+// Runs in the background while gitdone stages and diffs.
+func refreshHistoryIndexQuietly(ctx context.Context) {
+	index, err := loadHistoryIndex(ctx)
+	if err == nil {
+		err = updateHistoryIndex(ctx, index)
+	}
+	if err != nil && ctx.Err() == nil {
+		warn("Could not update the history index: %v\n", err)
+	}
+}
+
+// Drop rewritten commits from the index. They are no longer in the history,
+// and their old messages are the ones that were replaced.
+func forgetHistory(ctx context.Context, shas []string) error {
+	index, err := loadHistoryIndex(ctx)
+	if err != nil {
+		return err
+	}
+	kept := index.Commits[:0]
+	for _, entry := range index.Commits {
+		if !containsString(shas, entry.SHA) {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == len(index.Commits) {
+		return nil
+	}
+	index.Commits = kept
+	return saveHistoryIndex(ctx, index)
+}
+
+// Find the past commits most similar to the staged change, leaving out those in
+// exclude. Only what is indexed already is searched, so a cold index means no
+// examples rather than a wait for the whole history to be embedded.
+func findSimilarCommits(ctx context.Context, changeSummary string, exclude []string) ([]similarCommit, error) {
+	index, err := loadHistoryIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(index.Commits) == 0 {
+		return nil, nil
+	}
+
+	vector, err := model.Embed(ctx, changeSummary)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []similarCommit
+	for _, entry := range index.Commits {
+		if containsString(exclude, entry.SHA) {
+			continue
+		}
+		score := cosineSimilarity(vector, entry.Vector)
+		if score >= minSimilarity {
+			matches = append(matches, similarCommit{Message: entry.Message, Files: entry.Files, Score: score})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+	if len(matches) > similarCommitCount {
+		matches = matches[:similarCommitCount]
+	}
+	return matches, nil
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Format similar commits as examples for the generation prompt
+func formatSimilarCommits(commits []similarCommit) string {
+	if len(commits) == 0 {
+		return ""
+	}
+	var examples strings.Builder
+	examples.WriteString("Similar past commits in this repository:\n")
+	for _, commit := range commits {
+		examples.WriteString("\nFiles: ")
+		examples.WriteString(strings.Join(commit.Files, ", "))
+		examples.WriteString("\nMessage: ")
+		examples.WriteString(strings.SplitN(commit.Message, "\n", 2)[0])
+		examples.WriteByte('\n')
+	}
+	return examples.String()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Index the history up to HEAD, as earlier runs would have
+func indexHistory(t *testing.T) {
+	t.Helper()
+	index, err := loadHistoryIndex(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateHistoryIndex(context.Background(), index); err != nil {
+		t.Fatalf("updateHistoryIndex: %v", err)
+	}
+}
+
+func TestSimilarCommitsReachPrompt(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added cache expiry")
+
+	commit := func(name, content, msg string) {
+		repo.write(name, content)
+		repo.git("add", ".")
+		repo.git("commit", "-m", msg)
+	}
+	commit("cache.go", "package main\n\nfunc evictCache() {}\n", "Added cache eviction")
+	commit("zz.txt", "zzz zzz zzz zzz\n", "Fixed zz typo")
+	commit("cache.go", "package main\n\nfunc evictCache() {}\n\nfunc cacheSize() int { return 0 }\n", "wip")
+	indexHistory(t)
+
+	repo.write("cache.go", "package main\n\nfunc evictCache() {}\n\nfunc cacheSize() int { return 1 }\n\nfunc expireCache() {}\n")
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult()); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	prompts := server.receivedPrompts()
+	if len(prompts) != 1 {
+		t.Fatalf("got %d prompts", len(prompts))
+	}
+	examples := prompts[0]
+	if !strings.Contains(examples, "Similar past commits") || !strings.Contains(examples, "Files: cache.go\nMessage: Added cache eviction") {
+		t.Errorf("prompt lacks the related commit as an example:\n%s", examples)
+	}
+	// HEAD is the work this commit continues, not an example of a finished message
+	if strings.Contains(examples, "Message: wip") {
+		t.Errorf("prompt offers HEAD as an example:\n%s", examples)
+	}
+}
+
+func TestColdHistoryIndexSkipsExamples(t *testing.T) {
+	newTestRepo(t)
+	server := newFakeModelServer(t, "Added nothing")
+
+	similar, err := findSimilarCommits(context.Background(), "Changes to cache.go", nil)
+	if err != nil || similar != nil {
+		t.Errorf("findSimilarCommits on a cold index = %v, %v; want no examples", similar, err)
+	}
+	// Nothing is embedded while a run waits; the background refresh builds the index
+	server.set(func(f *fakeModelServer) {
+		if f.embedded != 0 {
+			t.Errorf("a cold lookup made %d embedding requests", f.embedded)
+		}
+	})
+}
+
+func TestHistoryIndexKeepsProgress(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added nothing")
+	for i := 0; i < 24; i++ {
+		repo.write("counter.txt", strings.Repeat("x", i+1))
+		repo.git("add", ".")
+		repo.git("commit", "-m", "Counted")
+	}
+
+	// The embedding model fails partway through the first build
+	server.set(func(f *fakeModelServer) { f.embedMax = 15 })
+	index, err := loadHistoryIndex(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updateHistoryIndex(context.Background(), index); err == nil {
+		t.Fatal("updateHistoryIndex succeeded with a failing embedding model")
+	}
+	if saved, _ := loadHistoryIndex(context.Background()); len(saved.Commits) != 15 {
+		t.Errorf("saved %d commits after the failure, want the 15 embedded", len(saved.Commits))
+	}
+
+	// The next build only embeds what is missing
+	server.set(func(f *fakeModelServer) { f.embedMax = 0; f.embedded = 0 })
+	indexHistory(t)
+	saved, _ := loadHistoryIndex(context.Background())
+	server.set(func(f *fakeModelServer) {
+		if len(saved.Commits) != 25 || f.embedded != 10 {
+			t.Errorf("index has %d commits after %d embeddings, want 25 after 10", len(saved.Commits), f.embedded)
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Points in a run where hooks can run
+const (
+	hookPreSummary   = "pre-summary"
+	hookPostGenerate = "post-generate"
+	hookPreCommit    = "pre-commit"
+	hookPostPush     = "post-push"
+)
+
+var hookPoints = []string{hookPreSummary, hookPostGenerate, hookPreCommit, hookPostPush}
+
+// Hook points whose output replaces the commit message
+var messageHooks = map[string]bool{hookPostGenerate: true, hookPreCommit: true}
+
+// Time allowed for each hook script
+const hookTimeout = 30 * time.Second
+
+// File in the git directory holding the fingerprint of the hooks the user
+// trusted. It is outside the work tree, so no commit can trust its own hooks.
+const trustedHooksFile = "trusted-hooks"
+
+// What a hook gets as JSON on stdin
+type hookInput struct {
+	Hook    string   `json:"hook"`
+	Files   []string `json:"files"`
+	Summary string   `json:"summary,omitempty"`
+	Message string   `json:"message,omitempty"`
+	Branch  string   `json:"branch,omitempty"`
+	Commit  string   `json:"commit,omitempty"`
+}
+
+// Check that the configured hooks name known hook points
+func validateHooks(hooks map[string][]string) error {
+	for name := range hooks {
+		known := false
+		for _, point := range hookPoints {
+			known = known || name == point
+		}
+		if !known {
+			return fmt.Errorf("Unknown hook %q in %s, expected one of %s", name, configFileName, strings.Join(hookPoints, ", "))
+		}
+	}
+	return nil
+}
+
+// Resolve a hook script: paths containing a slash are relative to the
+// repository root, bare names are left for PATH lookup
+func hookPath(root, script string) string {
+	if strings.Contains(script, "/") && !filepath.IsAbs(script) {
+		return filepath.Join(root, filepath.FromSlash(script))
+	}
+	return script
+}
+
+// Fingerprint the hook configuration along with the content of every script
+// given by path, so changing either one needs trusting again
+func hooksDigest(root string, hooks map[string][]string) (string, error) {
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		return "", fmt.Errorf("Error marshaling hooks: %v", err)
+	}
+	h := sha256.New()
+	h.Write(data)
+	for _, point := range hookPoints {
+		for _, script := range hooks[point] {
+			if !strings.Contains(script, "/") {
+				continue
+			}
+			// A missing script fails when it runs, so it only needs to count as different
+			content, _ := os.ReadFile(hookPath(root, script))
+			fmt.Fprintf(h, "\x00%s\x00%d\x00", script, len(content))
+			h.Write(content)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func trustedHooksPath(ctx context.Context) (string, error) {
+	dir, err := gitdoneDataDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, trustedHooksFile), nil
+}
+
+// Record the configured hooks, as they are now, as trusted in this clone
+func trustHooks(ctx context.Context, cfg *config) error {
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return err
+	}
+	digest, err := hooksDigest(root, cfg.Hooks)
+	if err != nil {
+		return err
+	}
+	path, err := trustedHooksPath(ctx)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(digest+"\n"), 0644); err != nil {
+		return fmt.Errorf("Error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+// Get a config whose hooks can run. The hooks in .gitdone.json come with the
+// repository, so they only run once the user has trusted them with gitdone
+// trust, and again after any of them changes.
+func withTrustedHooks(ctx context.Context, cfg *config) (*config, error) {
+	if len(cfg.Hooks) == 0 {
+		return cfg, nil
+	}
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := hooksDigest(root, cfg.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	path, err := trustedHooksPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if trusted, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(trusted)) == digest {
+		return cfg, nil
+	}
+
+	warn("Skipping the hooks in %s because they are not trusted in this clone. Review them, then run gitdone trust.\n", configFileName)
+	untrusted := *cfg
+	untrusted.Hooks = nil
+	return &untrusted, nil
+}
+
+// Run one hook executable with input as JSON on stdin, returning its stdout
+func runHookScript(ctx context.Context, root, script string, input hookInput) (string, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("Error marshaling hook input: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hookPath(root, script))
+	cmd.WaitDelay = 5 * time.Second
+	cmd.Dir = root
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(cmd.Environ(), "GITDONE_HOOK="+input.Hook)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("%s hook %s timed out after %v", input.Hook, script, hookTimeout)
+		}
+		reason := strings.TrimSpace(stderr.String())
+		if reason == "" {
+			reason = err.Error()
+		}
+		return "", fmt.Errorf("%s hook %s refused: %s", input.Hook, script, reason)
+	}
+	return stdout.String(), nil
+}
+
+// Run the hooks configured for a point in order. A hook that exits non-zero
+// vetoes the step. At post-generate and pre-commit, a hook that prints
+// something replaces the message. Returns the message, changed or not.
+func runHooks(ctx context.Context, cfg *config, input hookInput) (string, error) {
+	scripts := cfg.Hooks[input.Hook]
+	if len(scripts) == 0 {
+		return input.Message, nil
+	}
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return "", err
+	}
+	if input.Branch == "" {
+		input.Branch, _ = currentBranch(ctx)
+	}
+
+	for _, script := range scripts {
+		info("Running %s hook %s...\n", input.Hook, script)
+		out, err := runHookScript(ctx, root, script, input)
+		if err != nil {
+			return "", failPhase(exitHook, err)
+		}
+		if msg := strings.TrimSpace(out); msg != "" && messageHooks[input.Hook] {
+			input.Message = msg
+		}
+	}
+	return input.Message, nil
+}
+
+// Run a hook point's hooks as a phase of the run, skipping it when none are configured
+func runHookPhase(ctx context.Context, result *runResult, cfg *config, input hookInput) (string, error) {
+	if len(cfg.Hooks[input.Hook]) == 0 {
+		return input.Message, nil
+	}
+	input.Files = result.Files
+	// Each hook has its own time limit
+	p := startPhase(ctx, result, input.Hook, 0)
+	msg, err := runHooks(p.ctx, cfg, input)
+	return msg, p.end(exitHook, err)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write an executable hook script into the repository
+func (r *testRepo) hook(name, script string) {
+	r.t.Helper()
+	r.write(name, "#!/bin/sh\n"+script)
+	if err := os.Chmod(filepath.Join(r.Dir, name), 0755); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// Trust the hooks configured in the repository, as gitdone trust does
+func (r *testRepo) trustHooks() {
+	r.t.Helper()
+	if err := trustHooks(context.Background(), r.config()); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func TestHooksChangeMessageAndSeeTheCommit(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added cache layer")
+	out := t.TempDir()
+
+	repo.hook("hooks/link.sh", "cat > "+out+"/post-generate.json\necho 'Added cache layer\n\nhttps://jira.example.com/browse/PROJ-1'\n")
+	repo.hook("hooks/notify.sh", "cat > "+out+"/post-push.json\n")
+	repo.write(".gitdone.json", `{"hooks": {"post-generate": ["hooks/link.sh"], "post-push": ["hooks/notify.sh"]}}`)
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added hooks")
+	repo.trustHooks()
+	repo.write("cache.go", "package main\n\ntype cache struct{}\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	want := "Added cache layer\n\nhttps://jira.example.com/browse/PROJ-1"
+	if got := repo.git("log", "-1", "--format=%B"); got != want {
+		t.Errorf("commit message = %q, want %q", got, want)
+	}
+
+	var generated, pushed hookInput
+	for name, input := range map[string]*hookInput{"post-generate.json": &generated, "post-push.json": &pushed} {
+		data, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, input); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if generated.Message != "Added cache layer" || generated.Summary == "" || len(generated.Files) != 1 || generated.Branch != "main" {
+		t.Errorf("post-generate input = %+v", generated)
+	}
+	if pushed.Commit != result.Commit || pushed.Message != want {
+		t.Errorf("post-push input = %+v", pushed)
+	}
+}
+
+func TestHookVetoesCommit(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added WIP")
+	repo.hook("hooks/no-wip.sh", "echo 'WIP commits are not allowed' >&2\nexit 1\n")
+	repo.write(".gitdone.json", `{"hooks": {"pre-commit": ["hooks/no-wip.sh"]}}`)
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added hook")
+	repo.trustHooks()
+	head := repo.git("rev-parse", "HEAD")
+	repo.write("notes.txt", "staged by hand\n")
+	repo.git("add", "notes.txt")
+	repo.write("wip.go", "package main\n\nvar wip = true\n")
+
+	err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult())
+	if exitCode(err) != exitHook {
+		t.Fatalf("err = %v (exit %d), want hook veto", err, exitCode(err))
+	}
+	if got := repo.git("rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD moved to %s after a veto", got)
+	}
+	// What gitdone staged is unstaged again, what the user staged stays
+	if got := repo.git("status", "--porcelain"); got != "A  notes.txt\n?? wip.go" {
+		t.Errorf("status after a veto:\n%s", got)
+	}
+}
+
+func TestUntrustedHooksDoNotRun(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added notes")
+	marker := filepath.Join(t.TempDir(), "ran")
+	repo.hook("hooks/mark.sh", "touch "+marker+"\n")
+	repo.write(".gitdone.json", `{"hooks": {"pre-summary": ["hooks/mark.sh"]}}`)
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added hook")
+
+	run := func(name string) bool {
+		t.Helper()
+		os.Remove(marker)
+		repo.write(name, name+"\n")
+		if err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult()); err != nil {
+			t.Fatalf("runGitdone: %v", err)
+		}
+		_, err := os.Stat(marker)
+		return err == nil
+	}
+
+	// A fresh clone has not trusted the hooks it came with
+	if run("one.txt") {
+		t.Error("hook ran before it was trusted")
+	}
+	repo.trustHooks()
+	if !run("two.txt") {
+		t.Error("trusted hook did not run")
+	}
+	// A commit that changes the script needs trusting again
+	repo.hook("hooks/mark.sh", "touch "+marker+"\necho changed\n")
+	if run("three.txt") {
+		t.Error("hook ran after its script changed")
+	}
+	if trusted := repo.git("rev-parse", "--git-path", "gitdone/"+trustedHooksFile); !strings.HasPrefix(trusted, ".git/") {
+		t.Errorf("trust is kept at %s, want it inside .git", trusted)
+	}
+}
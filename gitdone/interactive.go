@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Time allowed for each answer on the hunk selection screen
+const selectionTimeout = 10 * time.Minute
+
+// A hunk of the working tree diff that can be staged on its own
+type selectableHunk struct {
+	Header   string // The @@ line
+	Lines    []string
+	Added    int
+	Removed  int
+	Selected bool
+}
+
+// A file of the working tree diff. Whole files (new, deleted, binary or
+// mode-only changes) are staged as a unit; the rest hunk by hunk.
+type selectableFile struct {
+	Path     string
+	Header   []string // Lines before the first hunk
+	Hunks    []*selectableHunk
+	Whole    bool
+	Selected bool // For whole files only
+	// Hunk lines past a reader's limit were left out
+	Truncated bool
+}
+
+func (f *selectableFile) isSelected() bool {
+	if f.Whole {
+		return f.Selected
+	}
+	for _, hunk := range f.Hunks {
+		if hunk.Selected {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *selectableFile) isNew() bool {
+	for _, line := range f.Header {
+		if strings.HasPrefix(line, "new file mode") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *selectableFile) setSelected(selected bool) {
+	f.Selected = selected
+	for _, hunk := range f.Hunks {
+		hunk.Selected = selected
+	}
+}
+
+// Parse a working tree diff into files and hunks, all selected
+func parseSelectableDiff(diff string) []*selectableFile {
+	var files []*selectableFile
+	// Reading from a string can't fail, and neither can collecting the files
+	readSelectableFiles(strings.NewReader(diff), 0, func(file *selectableFile) error {
+		files = append(files, file)
+		return nil
+	})
+	return files
+}
+
+// Read a diff file by file, passing each to fn as soon as it is complete, so
+// only one file is held in memory at a time. With maxLines above zero, a
+// file keeps at most that many hunk lines and is marked Truncated. Stops at
+// the first error fn returns.
+func readSelectableFiles(r io.Reader, maxLines int, fn func(*selectableFile) error) error {
+	var file *selectableFile
+	var hunk *selectableHunk
+	var fnErr error
+	lines := 0
+
+	finish := func() {
+		if file == nil || fnErr != nil {
+			return
+		}
+		if len(file.Hunks) == 0 {
+			file.Whole = true
+		}
+		fnErr = fn(file)
+	}
+
+	err := readDiffLines(r, func(line string) {
+		if fnErr != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			finish()
+			parts := strings.SplitN(line, " ", 4)
+			path := ""
+			if len(parts) == 4 {
+				path = strings.TrimPrefix(parts[3], "b/")
+			}
+			file = &selectableFile{Path: path, Header: []string{line}, Selected: true}
+			hunk = nil
+			lines = 0
+		case file == nil:
+		case strings.HasPrefix(line, "@@"):
+			if maxLines > 0 && lines >= maxLines {
+				file.Truncated = true
+				hunk = nil
+				return
+			}
+			hunk = &selectableHunk{Header: line, Selected: true}
+			file.Hunks = append(file.Hunks, hunk)
+		case hunk != nil:
+			if maxLines > 0 && lines >= maxLines {
+				file.Truncated = true
+				return
+			}
+			lines++
+			hunk.Lines = append(hunk.Lines, line)
+			if strings.HasPrefix(line, "+") {
+				hunk.Added++
+			} else if strings.HasPrefix(line, "-") {
+				hunk.Removed++
+			}
+		case file.Truncated:
+			// Lines of a hunk that was left out
+		default:
+			file.Header = append(file.Header, line)
+			if strings.HasPrefix(line, "new file mode") || strings.HasPrefix(line, "deleted file mode") ||
+				strings.HasPrefix(line, "Binary files ") {
+				file.Whole = true
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	finish()
+	return fnErr
+}
+
+// Build a patch holding only the selected hunks of the partly staged files
+func selectedPatch(files []*selectableFile) string {
+	var patch strings.Builder
+	for _, file := range files {
+		if !file.Whole {
+			writeSelectedHunks(&patch, file)
+		}
+	}
+	return patch.String()
+}
+
+// Build the patch of everything that will be committed, whole files included
+func previewPatch(files []*selectableFile) string {
+	var patch strings.Builder
+	for _, file := range files {
+		writeSelectedHunks(&patch, file)
+	}
+	return patch.String()
+}
+
+// Write a file's header and selected hunks, or nothing if none are selected
+func writeSelectedHunks(patch *strings.Builder, file *selectableFile) {
+	if !file.isSelected() {
+		return
+	}
+	for _, line := range file.Header {
+		patch.WriteString(line)
+		patch.WriteByte('\n')
+	}
+	for _, hunk := range file.Hunks {
+		if !hunk.Selected {
+			continue
+		}
+		patch.WriteString(hunk.Header)
+		patch.WriteByte('\n')
+		for _, line := range hunk.Lines {
+			patch.WriteString(line)
+			patch.WriteByte('\n')
+		}
+	}
+}
+
+// Stage the selection: whole files with git add, hunks with git apply --cached
+func stageSelection(ctx context.Context, files []*selectableFile) error {
+	for _, file := range files {
+		switch {
+		case file.Whole && file.Selected:
+			if _, err := gitClient.Run(ctx, "add", "-A", "--", ":(top)"+file.Path); err != nil {
+				return fmt.Errorf("Error staging %s: %v", file.Path, err)
+			}
+		case file.Whole && file.isNew():
+			// Drop the intent-to-add entry so the file is untracked again
+			if _, err := gitClient.Run(ctx, "rm", "--cached", "-q", "--", ":(top)"+file.Path); err != nil {
+				return fmt.Errorf("Error unstaging %s: %v", file.Path, err)
+			}
+		}
+	}
+
+	patch := selectedPatch(files)
+	if patch == "" {
+		return nil
+	}
+	dir, err := gitdoneDataDir(ctx)
+	if err != nil {
+		return err
+	}
+	patchFile := filepath.Join(dir, "selection.patch")
+	if err := os.WriteFile(patchFile, []byte(patch), 0644); err != nil {
+		return fmt.Errorf("Error writing the selection: %v", err)
+	}
+	defer os.Remove(patchFile)
+
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return err
+	}
+	// Skipped hunks shift the line numbers of later ones, which apply tolerates
+	if _, err := gitClient.Run(ctx, "-C", root, "apply", "--cached", patchFile); err != nil {
+		return fmt.Errorf("Error staging the selected hunks: %v", err)
+	}
+	return nil
+}
+
+// Print the files and hunks with their selection, and what will be committed
+func printSelection(files []*selectableFile) {
+	out := color.Output
+	selectedFiles, selectedHunks, added, removed := 0, 0, 0, 0
+	for i, file := range files {
+		mark := " "
+		if file.isSelected() {
+			mark = "x"
+			selectedFiles++
+		}
+		fmt.Fprintf(out, "[%s] %d  %s\n", mark, i+1, file.Path)
+		if file.Whole {
+			// New and deleted files are committed whole, hunks and all
+			if file.Selected {
+				for _, hunk := range file.Hunks {
+					selectedHunks++
+					added += hunk.Added
+					removed += hunk.Removed
+				}
+			}
+			continue
+		}
+		for j, hunk := range file.Hunks {
+			mark := " "
+			if hunk.Selected {
+				mark = "x"
+				selectedHunks++
+				added += hunk.Added
+				removed += hunk.Removed
+			}
+			fmt.Fprintf(out, "    [%s] %d.%d  %s (+%d -%d)\n", mark, i+1, j+1, hunk.Header, hunk.Added, hunk.Removed)
+		}
+	}
+	info("Will commit %d file(s), %d hunk(s), +%d -%d\n", selectedFiles, selectedHunks, added, removed)
+}
+
+// Toggle a file ("2") or a single hunk ("2.3"), reporting bad input
+func toggleSelection(files []*selectableFile, choice string) error {
+	fileNum, hunkNum, isHunk := strings.Cut(choice, ".")
+	i, err := strconv.Atoi(fileNum)
+	if err != nil || i < 1 || i > len(files) {
+		return fmt.Errorf("No file %s", fileNum)
+	}
+	file := files[i-1]
+	if !isHunk {
+		file.setSelected(!file.isSelected())
+		return nil
+	}
+	j, err := strconv.Atoi(hunkNum)
+	if err != nil || file.Whole || j < 1 || j > len(file.Hunks) {
+		return fmt.Errorf("No hunk %s", choice)
+	}
+	file.Hunks[j-1].Selected = !file.Hunks[j-1].Selected
+	return nil
+}
+
+// Let the user pick files and hunks of the working tree to stage, then stage them.
+// Untracked files are offered as new files. Changes already staged stay staged.
+func selectChanges(ctx context.Context, ask func(ctx context.Context, question string) (string, error)) error {
+	// Without --ignore-removal, add would stage deletions before they could be picked
+	if _, err := gitClient.Run(ctx, "add", "-N", "--ignore-removal", "."); err != nil {
+		return fmt.Errorf("Error listing untracked files: %v", err)
+	}
+	diff, err := gitClient.Run(ctx, "diff", "--no-color", "--no-ext-diff")
+	if err != nil {
+		return fmt.Errorf("Error reading working tree changes: %v", err)
+	}
+	files := parseSelectableDiff(diff)
+	if len(files) == 0 {
+		info("No unstaged changes to select from.\n")
+		return nil
+	}
+
+	help := "Toggle a file (2) or hunk (2.3), [a]ll, [n]one, [p]review patch, [d]one, [q]uit: "
+	for {
+		printSelection(files)
+		answer, err := ask(ctx, help)
+		if err != nil {
+			return failPhase(exitInterrupted, fmt.Errorf("Hunk selection stopped: %v", err))
+		}
+
+		switch answer = strings.ToLower(strings.TrimSpace(answer)); answer {
+		case "":
+			// Enter alone just shows the screen again
+		case "a", "n":
+			for _, file := range files {
+				file.setSelected(answer == "a")
+			}
+		case "p":
+			fmt.Fprint(color.Output, previewPatch(files))
+		case "d":
+			return stageSelection(ctx, files)
+		case "q":
+			return failPhase(exitInterrupted, fmt.Errorf("Hunk selection cancelled"))
+		default:
+			if err := toggleSelection(files, answer); err != nil {
+				warn("%v\n", err)
+			}
+		}
+	}
+}
+
+// Read one answer on the hunk selection screen; a variable so tests can script it
+var askSelection = func(ctx context.Context, question string) (string, error) {
+	return askUser(ctx, question, selectionTimeout)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+// Answer the hunk selection screen with answers, in order
+func scriptSelection(t *testing.T, answers ...string) {
+	t.Helper()
+	previous := askSelection
+	t.Cleanup(func() { askSelection = previous })
+	askSelection = func(context.Context, string) (string, error) {
+		if len(answers) == 0 {
+			return "", fmt.Errorf("out of answers")
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+}
+
+// Numbered lines, far enough apart that changes at both ends make two hunks
+func numberedLines(first, last string) string {
+	var lines strings.Builder
+	lines.WriteString(first + "\n")
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&lines, "// line %d\n", i)
+	}
+	lines.WriteString(last + "\n")
+	return lines.String()
+}
+
+func TestInteractiveCommitsOnlySelectedHunks(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added package comment")
+	repo.write("lines.go", numberedLines("package main", "var last = 1"))
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added lines")
+
+	repo.write("lines.go", numberedLines("// Package main has lines\npackage main", "var last = 2"))
+	repo.write("notes.txt", "scratch\n")
+	scriptSelection(t, "1.2", "2", "d")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{Interactive: true}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	committed := repo.git("show", "--format=", "HEAD")
+	if !strings.Contains(committed, "+// Package main has lines") || strings.Contains(committed, "var last = 2") {
+		t.Errorf("commit does not hold just the first hunk:\n%s", committed)
+	}
+	if got := repo.git("status", "--porcelain"); got != "M lines.go\n?? notes.txt" {
+		t.Errorf("unselected changes should stay in the working tree, status:\n%s", got)
+	}
+	if prompts := server.receivedPrompts(); len(prompts) != 1 || strings.Contains(prompts[0], "notes.txt") {
+		t.Errorf("prompt should cover only the selection: %q", prompts)
+	}
+}
+
+func TestInteractiveQuitRestoresIndex(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added notes")
+	repo.write("notes.txt", "scratch\n")
+	scriptSelection(t, "q")
+
+	err := runGitdone(context.Background(), repo.config(), commitOptions{Interactive: true}, newRunResult())
+	if exitCode(err) != exitInterrupted {
+		t.Fatalf("err = %v (exit %d), want interrupted", err, exitCode(err))
+	}
+	if got := repo.git("status", "--porcelain"); got != "?? notes.txt" {
+		t.Errorf("status after quitting = %q, want notes.txt untracked", got)
+	}
+}
+
+func TestInteractiveEmptyAnswerAsksAgain(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added notes")
+	repo.write("notes.txt", "scratch\n")
+	scriptSelection(t, "", "  ", "d")
+
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{Interactive: true}, newRunResult()); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	if got := repo.git("log", "-1", "--format=%s"); got != "Added notes" {
+		t.Errorf("commit subject = %q", got)
+	}
+}
+
+func TestInteractiveTotalsIncludeWholeFiles(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added notes")
+	repo.write("notes.txt", "one\ntwo\n")
+	repo.git("rm", "-q", "main.go")
+	repo.git("reset", "-q")
+
+	var out strings.Builder
+	previous := color.Output
+	color.Output = &out
+	t.Cleanup(func() { color.Output = previous })
+	scriptSelection(t, "p", "q")
+
+	selectChanges(context.Background(), askSelection)
+	if !strings.Contains(out.String(), "Will commit 2 file(s), 2 hunk(s), +2 -3") {
+		t.Errorf("totals leave out the new and deleted files:\n%s", out.String())
+	}
+	for _, want := range []string{"+++ b/notes.txt", "+two", "--- a/main.go", "-func main() {}"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("preview missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What one gitdone run did, recorded so it can be undone
+type journalEntry struct {
+	Time      time.Time `json:"time"`
+	Branch    string    `json:"branch"`
+	Head      string    `json:"head"`      // HEAD before the run, empty if the repository had no commits
+	IndexTree string    `json:"indexTree"` // Staged state before the run, empty if it had conflicts
+	Commit    string    `json:"commit"`
+	Remote    string    `json:"remote"`
+	Pushed    bool      `json:"pushed"`
+	Undone    bool      `json:"undone"`
+}
+
+func journalPath(ctx context.Context) (string, error) {
+	dir, err := gitdoneDataDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "journal.jsonl"), nil
+}
+
+// Read all journal entries, oldest first
+func loadJournal(ctx context.Context) ([]journalEntry, error) {
+	path, err := journalPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Error reading journal: %v", err)
+	}
+	defer file.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Error parsing journal: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func saveJournal(ctx context.Context, entries []journalEntry) error {
+	path, err := journalPath(ctx)
+	if err != nil {
+		return err
+	}
+	var data strings.Builder
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("Error marshaling journal entry: %v", err)
+		}
+		data.Write(line)
+		data.WriteByte('\n')
+	}
+	return os.WriteFile(path, []byte(data.String()), 0644)
+}
+
+// Append an entry for a run that made a commit
+func appendJournal(ctx context.Context, entry journalEntry) error {
+	path, err := journalPath(ctx)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Error marshaling journal entry: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening journal: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Record a finished run in the journal if it made a commit
+func journalRun(snapshot *repoSnapshot, result *runResult) {
+	if result.Commit == "" {
+		return
+	}
+	// The run's context may already be cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+
+	entry := journalEntry{
+		Time:      time.Now(),
+		Branch:    result.Branch,
+		Head:      snapshot.Head,
+		IndexTree: snapshot.Index,
+		Commit:    result.Commit,
+		Remote:    "origin",
+		Pushed:    result.Pushed,
+	}
+	if err := appendJournal(ctx, entry); err != nil {
+		warn("Could not record this run for gitdone undo: %v\n", err)
+	}
+}
+
+// Ask a yes/no question, answering yes without asking under --yes
+func confirm(ctx context.Context, question string) bool {
+	if assumeYes {
+		return true
+	}
+	answer, err := askUser(ctx, question+" [y/N] ", userTimeout)
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Undo the most recent gitdone commit: reset it and restore the staged state
+// if it was never pushed, or offer a revert commit if it was
+func undoLastRun(ctx context.Context) error {
+	if err := refuseInProgress(ctx); err != nil {
+		return err
+	}
+	entries, err := loadJournal(ctx)
+	if err != nil {
+		return err
+	}
+	last := -1
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Undone {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return fmt.Errorf("No gitdone commit to undo")
+	}
+	entry := entries[last]
+
+	head, err := gitClient.Run(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return fmt.Errorf("Error reading HEAD: %v", err)
+	}
+	if strings.TrimSpace(head) != entry.Commit {
+		return fmt.Errorf("HEAD has moved since gitdone made %s; undo it by hand", shortSHA(entry.Commit))
+	}
+
+	// The push queue or a manual push may have published it since
+	pushed := entry.Pushed
+	if !pushed {
+		upstream, err := upstreamRef(ctx)
+		if err != nil {
+			return err
+		}
+		if pushed, err = isPublished(ctx, entry.Commit, upstream); err != nil {
+			return err
+		}
+	}
+
+	if pushed {
+		if err := offerRevert(ctx, entry); err != nil {
+			return err
+		}
+	} else {
+		if err := resetRun(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	entries[last].Undone = true
+	return saveJournal(ctx, entries)
+}
+
+// Drop an unpushed commit and put the index back the way it was before the run
+func resetRun(ctx context.Context, entry journalEntry) error {
+	var err error
+	if entry.Head == "" {
+		// The commit was the first in the repository
+		_, err = gitClient.Run(ctx, "update-ref", "-d", "HEAD")
+	} else {
+		_, err = gitClient.Run(ctx, "reset", "--soft", entry.Head)
+	}
+	if err != nil {
+		return fmt.Errorf("Error resetting %s: %v", shortSHA(entry.Commit), err)
+	}
+
+	// An index that had conflicts was never saved, so it stays as committed
+	if entry.IndexTree != "" {
+		if _, err := gitClient.Run(ctx, "read-tree", entry.IndexTree); err != nil {
+			return fmt.Errorf("Error restoring the index: %v", err)
+		}
+	}
+	success("Undid commit %s; your changes are back in the working tree as they were before gitdone ran.\n", shortSHA(entry.Commit))
+	return nil
+}
+
+// Offer to revert a commit that is already pushed
+func offerRevert(ctx context.Context, entry journalEntry) error {
+	command := "git revert --no-edit " + entry.Commit
+	warn("Commit %s is already pushed to %s/%s, so it can't be reset safely.\n", shortSHA(entry.Commit), entry.Remote, entry.Branch)
+	info("It can be undone with a revert commit:\n  %s\n", command)
+
+	if !confirm(ctx, "Create the revert commit now?") {
+		return fmt.Errorf("Undo cancelled")
+	}
+	if _, err := gitClient.Run(ctx, "revert", "--no-edit", entry.Commit); err != nil {
+		return fmt.Errorf("Error reverting %s: %v", shortSHA(entry.Commit), err)
+	}
+	success("Reverted %s; push to publish the revert.\n", shortSHA(entry.Commit))
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestUndoUnpushedCommitRestoresIndex(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added parser")
+
+	repo.git("remote", "set-url", "origin", repo.Remote+"-missing")
+	repo.write("staged.go", "package main\n")
+	repo.git("add", "staged.go")
+	repo.write("parser.go", "package main\n\nfunc parse() {}\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); exitCode(err) != exitPush {
+		t.Fatalf("runGitdone: %v, want push failure", err)
+	}
+
+	if err := undoLastRun(context.Background()); err != nil {
+		t.Fatalf("undoLastRun: %v", err)
+	}
+	if got := repo.git("log", "-1", "--format=%s"); got != "Added main package" {
+		t.Errorf("HEAD after undo = %q", got)
+	}
+	if got := repo.git("status", "--porcelain"); got != "A  staged.go\n?? parser.go" {
+		t.Errorf("status after undo = %q", got)
+	}
+	if err := undoLastRun(context.Background()); err == nil {
+		t.Error("second undo succeeded with nothing left to undo")
+	}
+}
+
+func TestUndoPushedCommitReverts(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added parser")
+
+	previous := assumeYes
+	assumeYes = true
+	t.Cleanup(func() { assumeYes = previous })
+
+	repo.write("parser.go", "package main\n\nfunc parse() {}\n")
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	if err := undoLastRun(context.Background()); err != nil {
+		t.Fatalf("undoLastRun: %v", err)
+	}
+	message := repo.git("log", "-1", "--format=%B")
+	if !strings.HasPrefix(message, `Revert "Added parser"`) || !strings.Contains(message, result.Commit) {
+		t.Errorf("revert message = %q", message)
+	}
+	if got := repo.git("ls-files", "parser.go"); got != "" {
+		t.Errorf("parser.go still tracked after revert")
+	}
+}
+
+func TestUndoRefusesWhenHeadMoved(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added parser")
+
+	repo.write("parser.go", "package main\n")
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult()); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	repo.git("commit", "--allow-empty", "-m", "Manual commit")
+
+	if err := undoLastRun(context.Background()); err == nil || !strings.Contains(err.Error(), "HEAD has moved") {
+		t.Fatalf("err = %v, want refusal", err)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The language model gitdone generates messages and embeddings with
+type modelClient interface {
+	// Generate a completion for a prompt
+	Generate(ctx context.Context, prompt string) (string, error)
+	// Embed text as a vector for similarity search
+	Embed(ctx context.Context, text string) ([]float64, error)
+	// Load the model ahead of the first Generate call
+	Warm(ctx context.Context) error
+}
+
+// Model client for a local Ollama server
+type ollamaClient struct {
+	GenerateURL    string
+	EmbeddingsURL  string
+	Model          string
+	EmbeddingModel string
+	Timeout        time.Duration
+}
+
+func newOllamaClient() *ollamaClient {
+	return &ollamaClient{
+		GenerateURL:    ollamaAPIURL,
+		EmbeddingsURL:  ollamaEmbeddingsURL,
+		Model:          modelName,
+		EmbeddingModel: embeddingModel,
+		Timeout:        timeout,
+	}
+}
+
+// Sampling temperature for generation; low, since commit messages should be predictable
+const generateTemperature = 0.2
+
+// The model used by the commands; tests replace it with a fake server
+var model modelClient = newOllamaClient()
+
+// Call the Ollama generate API with a given prompt
+func (c *ollamaClient) Generate(ctx context.Context, prompt string) (string, error) {
+	requestBody := map[string]interface{}{
+		"model":  c.Model,
+		"prompt": prompt,
+		// Ollama only reads sampling parameters from options
+		"options":    map[string]interface{}{"temperature": generateTemperature},
+		"stream":     true,
+		"keep_alive": modelKeepAlive,
+	}
+
+	jsonBody, err := json.Marshal(requestBody)
+	if err != nil {
+		return "", fmt.Errorf("Error marshaling request body: %v", err)
+	}
+
+	// Create a client with custom timeout and keep-alive settings
+	client := &http.Client{
+		Timeout: c.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+
+	var responseText string
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(attemptCtx, "POST", c.GenerateURL, bytes.NewBuffer(jsonBody))
+		if err != nil {
+			return "", fmt.Errorf("Error creating request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			// Retrying is pointless once the whole run is cancelled
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("Error calling model: %w", ctx.Err())
+			}
+			if attempt < maxRetries {
+				if err := sleepContext(ctx, time.Duration(attempt)*retryDelay); err != nil {
+					return "", err
+				}
+				continue
+			}
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			if attempt < maxRetries {
+				if err := sleepContext(ctx, time.Duration(attempt)*retryDelay); err != nil {
+					return "", err
+				}
+				continue
+			}
+			return "", fmt.Errorf("API returned status code: %d", resp.StatusCode)
+		}
+
+		reader := bufio.NewReader(resp.Body)
+		var fullResponse strings.Builder
+
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				if ctx.Err() != nil {
+					return "", fmt.Errorf("Error reading response: %w", ctx.Err())
+				}
+				return "", fmt.Errorf("Error reading response: %v", err)
+			}
+
+			var ollamaResp map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &ollamaResp); err != nil {
+				continue
+			}
+
+			if response, ok := ollamaResp["response"].(string); ok {
+				fullResponse.WriteString(response)
+			}
+
+			if done, ok := ollamaResp["done"].(bool); ok && done {
+				break
+			}
+		}
+
+		responseText = strings.TrimSpace(fullResponse.String())
+		if responseText != "" {
+			break
+		}
+	}
+
+	if responseText == "" {
+		return "", fmt.Errorf("Failed to get a valid response after %d attempts", maxRetries)
+	}
+
+	return responseText, nil
+}
+
+// How long Ollama keeps the model loaded after a request, so the next run starts warm
+const modelKeepAlive = "10m"
+
+// Ask Ollama to load the model. A generate request without a prompt
+// only loads it, which takes a while the first time.
+func (c *ollamaClient) Warm(ctx context.Context) error {
+	jsonBody, err := json.Marshal(map[string]interface{}{
+		"model":      c.Model,
+		"keep_alive": modelKeepAlive,
+	})
+	if err != nil {
+		return fmt.Errorf("Error marshaling request body: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.GenerateURL, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return fmt.Errorf("Error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Call the Ollama embeddings endpoint for a piece of text
+func (c *ollamaClient) Embed(ctx context.Context, text string) ([]float64, error) {
+	jsonBody, err := json.Marshal(map[string]interface{}{
+		"model":  c.EmbeddingModel,
+		"prompt": text,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling request body: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.EmbeddingsURL, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Embeddings API returned status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Embedding []float64 `json:"embedding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("Error decoding embeddings response: %v", err)
+	}
+	if len(result.Embedding) == 0 {
+		return nil, fmt.Errorf("Embeddings API returned an empty vector")
+	}
+	return result.Embedding, nil
+}
+
+// Sleep for d, returning early with an error if ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Error calling model: %w", ctx.Err())
+	}
+}
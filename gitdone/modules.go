@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultMaxScopes = 3
+
+// Files whose presence marks the root of a module
+var moduleMarkers = []string{"go.mod", "package.json"}
+
+// The changed files that belong to one module of the repository
+type moduleChanges struct {
+	Root  string // Slash-separated path from the repository root, "." for the root itself
+	Files []*fileChanges
+}
+
+// Name used for the module in a commit subject scope
+func (m *moduleChanges) Scope() string {
+	return path.Base(m.Root)
+}
+
+// Find the module a file belongs to: the longest configured root containing it,
+// or else the nearest of moduleDirs above it
+func findModuleRoot(file string, cfg *config, moduleDirs map[string]bool) string {
+	best := ""
+	for _, moduleRoot := range cfg.ModuleRoots {
+		moduleRoot = strings.Trim(filepath.ToSlash(moduleRoot), "/")
+		if (file == moduleRoot || strings.HasPrefix(file, moduleRoot+"/")) && len(moduleRoot) > len(best) {
+			best = moduleRoot
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	dir := path.Dir(file)
+	for dir != "." && !moduleDirs[dir] {
+		dir = path.Dir(dir)
+	}
+	return dir
+}
+
+// Find the directories above the changed files that have a module marker
+// file on either side of source. A module whose go.mod is being deleted still
+// counts, so its removal is scoped to it.
+func findModuleDirs(ctx context.Context, root string, files []*fileChanges, source diffSource) (map[string]bool, error) {
+	dirs := make(map[string]bool)
+	seen := make(map[string]bool)
+	var markers []string
+	for _, file := range files {
+		for dir := path.Dir(file.Path); dir != "." && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			for _, marker := range moduleMarkers {
+				markers = append(markers, dir+"/"+marker)
+			}
+		}
+	}
+	if len(markers) == 0 {
+		return dirs, nil
+	}
+
+	// List the markers that exist at a revision, or in the index if rev is empty
+	list := func(rev string) error {
+		args := []string{"--literal-pathspecs", "-C", root, "ls-files", "--cached", "--"}
+		if rev != "" {
+			args = []string{"--literal-pathspecs", "-C", root, "ls-tree", "-r", "--name-only", rev, "--"}
+		}
+		out, err := gitClient.Run(ctx, append(args, markers...)...)
+		if err != nil {
+			return err
+		}
+		for _, marker := range strings.Split(strings.TrimSpace(out), "\n") {
+			if marker != "" {
+				dirs[path.Dir(marker)] = true
+			}
+		}
+		return nil
+	}
+	if err := list(source.After); err != nil {
+		return nil, fmt.Errorf("Error finding modules: %v", err)
+	}
+	// Before the first commit there is no earlier side
+	list(source.Before)
+	return dirs, nil
+}
+
+// Group changed files by the module they belong to, in order of first appearance.
+// Module boundaries are those at source, not in the working tree.
+func groupByModule(ctx context.Context, files []*fileChanges, source diffSource, cfg *config) ([]*moduleChanges, error) {
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	moduleDirs, err := findModuleDirs(ctx, root, files, source)
+	if err != nil {
+		return nil, err
+	}
+
+	byRoot := make(map[string]*moduleChanges)
+	var modules []*moduleChanges
+	for _, file := range files {
+		moduleRoot := findModuleRoot(file.Path, cfg, moduleDirs)
+		module, ok := byRoot[moduleRoot]
+		if !ok {
+			module = &moduleChanges{Root: moduleRoot}
+			byRoot[moduleRoot] = module
+			modules = append(modules, module)
+		}
+		module.Files = append(module.Files, file)
+	}
+	return modules, nil
+}
+
+// Format the change summary grouped per module
+func formatModuleSummary(modules []*moduleChanges) string {
+	switch len(modules) {
+	case 0:
+		return formatChangeSummary(nil)
+	case 1:
+		return formatChangeSummary(modules[0].Files)
+	}
+
+	var summary strings.Builder
+	summary.WriteString("Modules changed:\n")
+	for _, module := range modules {
+		fmt.Fprintf(&summary, "* %s (%d files)\n", module.Root, len(module.Files))
+	}
+	for _, module := range modules {
+		fmt.Fprintf(&summary, "\n=== Module %s ===\n", module.Root)
+		summary.WriteString(formatChangeSummaryWithin(module.Files, maxSummarySize/len(modules)))
+	}
+	return summary.String()
+}
+
+// Get the scopes for the commit subject, or nil when the change has none
+// or touches too many modules for one commit
+func commitScopes(modules []*moduleChanges, cfg *config) []string {
+	maxScopes := cfg.MaxScopes
+	if maxScopes <= 0 {
+		maxScopes = defaultMaxScopes
+	}
+
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, module := range modules {
+		if module.Root == "." {
+			continue
+		}
+		if scope := module.Scope(); !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if len(scopes) > maxScopes {
+		roots := make([]string, 0, len(modules))
+		for _, module := range modules {
+			roots = append(roots, module.Root)
+		}
+		sort.Strings(roots)
+		warn("This change touches %d modules (%s); consider splitting it into one commit per module.\n",
+			len(modules), strings.Join(roots, ", "))
+		return nil
+	}
+	return scopes
+}
+
+// Prefix the commit subject with its module scopes
+func applyScopes(msg string, scopes []string) string {
+	if len(scopes) == 0 {
+		return msg
+	}
+	return formatCommitMessage(strings.Join(scopes, ", ") + ": " + msg)
+}
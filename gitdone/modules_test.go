@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestModuleGroupingAndScopes(t *testing.T) {
+	// A repository with nested Go and npm modules, a configured root and loose files
+	base := map[string]string{
+		"services/api/go.mod":                 "module example.com/api\n",
+		"services/api/handlers/user.go":       "package handlers\n",
+		"services/api/internal/db/go.mod":     "module example.com/db\n",
+		"services/api/internal/db/db.go":      "package db\n",
+		"web/package.json":                    "{\"name\": \"web\"}\n",
+		"web/src/app.js":                      "export {}\n",
+		"libs/extra/extra.go":                 "package extra\n",
+		"tools/gen.go":                        "package tools\n",
+		"services/worker/package.json":        "{\"name\": \"worker\"}\n",
+		"services/worker/jobs/nightly/run.js": "export {}\n",
+	}
+
+	tests := []struct {
+		name    string
+		config  string
+		changed []string
+		deleted []string
+		modules map[string][]string // Module root to the files grouped under it
+		subject string
+	}{
+		{
+			name:    "nested file in one module",
+			changed: []string{"services/api/handlers/user.go"},
+			modules: map[string][]string{"services/api": {"services/api/handlers/user.go"}},
+			subject: "api: Added user lookup",
+		},
+		{
+			name:    "nearest module wins over its parent",
+			changed: []string{"services/api/handlers/user.go", "services/api/internal/db/db.go"},
+			modules: map[string][]string{
+				"services/api":             {"services/api/handlers/user.go"},
+				"services/api/internal/db": {"services/api/internal/db/db.go"},
+			},
+			subject: "api, db: Added user lookup",
+		},
+		{
+			name:    "go and npm modules",
+			changed: []string{"services/api/handlers/user.go", "web/src/app.js", "services/worker/jobs/nightly/run.js"},
+			modules: map[string][]string{
+				"services/api":    {"services/api/handlers/user.go"},
+				"web":             {"web/src/app.js"},
+				"services/worker": {"services/worker/jobs/nightly/run.js"},
+			},
+			// Scopes follow the order of the diff, which is by path
+			subject: "api, worker, web: Added user lookup",
+		},
+		{
+			name:    "files outside any module have no scope",
+			changed: []string{"tools/gen.go", "web/src/app.js"},
+			modules: map[string][]string{".": {"tools/gen.go"}, "web": {"web/src/app.js"}},
+			subject: "web: Added user lookup",
+		},
+		{
+			name:    "deleting a module's go.mod keeps its scope",
+			changed: []string{"services/api/handlers/user.go"},
+			deleted: []string{"services/api/go.mod"},
+			modules: map[string][]string{"services/api": {"services/api/go.mod", "services/api/handlers/user.go"}},
+			subject: "api: Added user lookup",
+		},
+		{
+			name:    "configured module root",
+			config:  `{"moduleRoots": ["libs/extra"]}`,
+			changed: []string{"libs/extra/extra.go"},
+			modules: map[string][]string{"libs/extra": {"libs/extra/extra.go"}},
+			subject: "extra: Added user lookup",
+		},
+		{
+			name:    "too many modules drop the scope",
+			config:  `{"maxScopes": 1}`,
+			changed: []string{"services/api/handlers/user.go", "web/src/app.js"},
+			modules: map[string][]string{"services/api": {"services/api/handlers/user.go"}, "web": {"web/src/app.js"}},
+			subject: "Added user lookup",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepo(t)
+			newFakeModelServer(t, "Added user lookup")
+			for name, content := range base {
+				repo.write(name, content)
+			}
+			if tt.config != "" {
+				repo.write(configFileName, tt.config)
+			}
+			repo.git("add", ".")
+			repo.git("commit", "-m", "Added modules")
+
+			for _, name := range tt.changed {
+				repo.write(name, base[name]+"\n// changed\n")
+			}
+			for _, name := range tt.deleted {
+				repo.git("rm", "-q", name)
+			}
+			repo.git("add", ".")
+			cfg := repo.config()
+
+			files, err := readStagedChanges(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			modules, err := groupByModule(context.Background(), files, stagedChanges, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string][]string)
+			for _, module := range modules {
+				for _, file := range module.Files {
+					got[module.Root] = append(got[module.Root], file.Path)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.modules) {
+				t.Errorf("modules = %v, want %v", got, tt.modules)
+			}
+
+			if err := runGitdone(context.Background(), cfg, commitOptions{}, newRunResult()); err != nil {
+				t.Fatalf("runGitdone: %v", err)
+			}
+			if got := repo.git("log", "-1", "--format=%s"); got != tt.subject {
+				t.Errorf("subject = %q, want %q", got, tt.subject)
+			}
+		})
+	}
+}
+
+func TestRewordScopesByCommittedModules(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added user lookup")
+	repo.write("services/api/go.mod", "module example.com/api\n")
+	repo.write("web/package.json", "{\"name\": \"web\"}\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added modules")
+	repo.write("services/api/handlers/user.go", "package handlers\n")
+	repo.write("web/src/app.js", "export {}\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "wip")
+	wip := repo.git("rev-parse", "HEAD")
+	// The api module has since been folded into the root module
+	repo.git("rm", "-q", "services/api/go.mod")
+	repo.git("commit", "-m", "Folded api into the root module")
+
+	if _, err := rewordRange(context.Background(), wip+"^.."+wip, repo.config(), commitOptions{}, false); err != nil {
+		t.Fatalf("rewordRange: %v", err)
+	}
+	if got := repo.git("log", "-1", "--format=%s", "HEAD~1"); got != "api, web: Added user lookup" {
+		t.Errorf("reworded subject = %q, want the scopes of the modules at the time", got)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Exit codes, one per failure class
+const (
+	exitOK          = 0  // Committed and pushed
+	exitError       = 1  // Unexpected error
+	exitUsage       = 2  // Invalid flags or arguments
+	exitNotRepo     = 3  // Not in a git repository, or the repository config is invalid
+	exitNoChanges   = 4  // Nothing to commit
+	exitGenerate    = 5  // The model failed to produce a commit message
+	exitCommit      = 6  // git commit failed, or the commit signature did not verify
+	exitPush        = 7  // The commit was made but git push failed
+	exitTimeout     = 8  // A phase ran past its time limit
+	exitInterrupted = 9  // The run was interrupted with Ctrl-C or SIGTERM
+	exitConflict    = 10 // A merge, rebase, cherry-pick or revert has unresolved conflicts
+	exitHook        = 11 // A hook script vetoed the run
+	exitReview      = 12 // The review found high-severity problems
+)
+
+var errNoChanges = errors.New("no changes to commit")
+
+// An error tagged with the exit code of its failure class
+type phaseError struct {
+	code int
+	err  error
+}
+
+func (e *phaseError) Error() string { return e.err.Error() }
+func (e *phaseError) Unwrap() error { return e.err }
+
+// Tag an error with the exit code of the phase that produced it
+func failPhase(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &phaseError{code: code, err: err}
+}
+
+// Get the exit code for an error returned by a run
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if errors.Is(err, errNoChanges) {
+		return exitNoChanges
+	}
+	var pe *phaseError
+	if errors.As(err, &pe) {
+		return pe.code
+	}
+	return exitError
+}
+
+// Result of a run, emitted as JSON with --output json
+type runResult struct {
+	Files     []string         `json:"files"`
+	Summary   string           `json:"summary"`
+	Message   string           `json:"message"`
+	Commit    string           `json:"commit,omitempty"`
+	Branch    string           `json:"branch,omitempty"`
+	Pushed    bool             `json:"pushed"`
+	PushError string           `json:"pushError,omitempty"`
+	Queued    bool             `json:"queued"` // The remote was unreachable and the push was queued
+	Findings  []reviewFinding  `json:"findings,omitempty"`
+	Steps     []string         `json:"steps"`
+	Timings   map[string]int64 `json:"timingsMs"`
+	Error     string           `json:"error,omitempty"`
+	ExitCode  int              `json:"exitCode"`
+}
+
+func newRunResult() *runResult {
+	return &runResult{Files: []string{}, Steps: []string{}, Timings: make(map[string]int64)}
+}
+
+// Start timing a phase; call the returned function when the phase ends
+func (r *runResult) time(phase string) func() {
+	start := time.Now()
+	return func() {
+		r.Timings[phase] = time.Since(start).Milliseconds()
+	}
+}
+
+// Output settings chosen on the command line
+var (
+	jsonOutput  bool // Emit a JSON result on stdout instead of text
+	assumeYes   bool // Never prompt and never draw the spinner
+	showTimings bool // Print how long each phase took
+)
+
+// Configure output for --output and --yes
+func configureOutput(format string, yes bool) error {
+	switch format {
+	case "text":
+	case "json":
+		jsonOutput = true
+		// Keep stdout for the JSON result; progress messages go to stderr
+		color.Output = color.Error
+	default:
+		return fmt.Errorf("Unknown output format %q, expected \"text\" or \"json\"", format)
+	}
+	assumeYes = yes
+	return nil
+}
+
+// Report the outcome of a run and return its exit code
+func finishRun(result *runResult, err error) int {
+	result.ExitCode = exitCode(err)
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if encodeErr := encoder.Encode(result); encodeErr != nil {
+			errorLog("Error encoding result: %v\n", encodeErr)
+			return exitError
+		}
+		return result.ExitCode
+	}
+
+	switch {
+	case err == nil:
+		success("\ngitdone completed successfully.\n")
+	case result.ExitCode == exitNoChanges:
+		warn("No changes to commit.\n")
+	default:
+		errorLog("Error: %v\n", err)
+	}
+	if showTimings {
+		printTimings(result.Timings)
+	}
+	return result.ExitCode
+}
+
+// Phases that run alongside the others, so they don't add to the total
+var backgroundPhaseNames = []string{"flush", "warmup", "index"}
+
+// Print the phase timings, slowest first, with the total last
+func printTimings(timings map[string]int64) {
+	phases := make([]string, 0, len(timings))
+	for phase := range timings {
+		if phase != "total" {
+			phases = append(phases, phase)
+		}
+	}
+	sort.Slice(phases, func(i, j int) bool {
+		if timings[phases[i]] != timings[phases[j]] {
+			return timings[phases[i]] > timings[phases[j]]
+		}
+		return phases[i] < phases[j]
+	})
+
+	info("\nTimings:\n")
+	for _, phase := range phases {
+		note := ""
+		if containsString(backgroundPhaseNames, phase) {
+			note = " (in background)"
+		}
+		info("  %-14s %6d ms%s\n", phase, timings[phase], note)
+	}
+	if total, ok := timings["total"]; ok {
+		info("  %-14s %6d ms\n", "total", total)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+// Run gitdone as gitdone --output json --yes does, returning the exit code
+// and the JSON result it printed on stdout
+func runJSON(t *testing.T) (int, runResult) {
+	t.Helper()
+	previousOutput, previousJSON, previousYes := color.Output, jsonOutput, assumeYes
+	previousStdout := os.Stdout
+	t.Cleanup(func() {
+		color.Output, jsonOutput, assumeYes = previousOutput, previousJSON, previousYes
+		os.Stdout = previousStdout
+	})
+
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	os.Stdout = stdout
+	if err := configureOutput("json", true); err != nil {
+		t.Fatal(err)
+	}
+	// Progress goes to stderr in JSON mode; keep it out of the test log
+	color.Output = previousOutput
+
+	code := run(context.Background(), commitOptions{}, newRunResult())
+	os.Stdout = previousStdout
+
+	data, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result runResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("stdout is not a JSON result: %v\n%s", err, data)
+	}
+	return code, result
+}
+
+func TestRunJSONResult(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(repo *testRepo, server *fakeModelServer)
+		code  int
+		check func(t *testing.T, repo *testRepo, result runResult)
+	}{
+		{
+			name: "success",
+			setup: func(repo *testRepo, server *fakeModelServer) {
+				repo.write("config.go", "package main\n\nvar loaded bool\n")
+			},
+			code: exitOK,
+			check: func(t *testing.T, repo *testRepo, result runResult) {
+				if result.Commit != repo.git("rev-parse", "HEAD") || !result.Pushed || result.Error != "" {
+					t.Errorf("commit=%q pushed=%v error=%q", result.Commit, result.Pushed, result.Error)
+				}
+				if result.Message != "Added config loader" || len(result.Files) != 1 || result.Files[0] != "config.go" {
+					t.Errorf("message=%q files=%v", result.Message, result.Files)
+				}
+				if _, ok := result.Timings["total"]; !ok {
+					t.Errorf("timings = %v, want a total", result.Timings)
+				}
+			},
+		},
+		{
+			name:  "no changes",
+			setup: func(repo *testRepo, server *fakeModelServer) {},
+			code:  exitNoChanges,
+			check: func(t *testing.T, repo *testRepo, result runResult) {
+				if result.Error == "" || result.Commit != "" || len(result.Files) != 0 {
+					t.Errorf("error=%q commit=%q files=%v", result.Error, result.Commit, result.Files)
+				}
+			},
+		},
+		{
+			name: "generate failure",
+			setup: func(repo *testRepo, server *fakeModelServer) {
+				server.set(func(f *fakeModelServer) { f.status = http.StatusInternalServerError })
+				repo.write("config.go", "package main\n\nvar loaded bool\n")
+			},
+			code: exitGenerate,
+			check: func(t *testing.T, repo *testRepo, result runResult) {
+				if result.Error == "" || result.Commit != "" || result.Message != "" {
+					t.Errorf("error=%q commit=%q message=%q", result.Error, result.Commit, result.Message)
+				}
+			},
+		},
+		{
+			name: "push failure",
+			setup: func(repo *testRepo, server *fakeModelServer) {
+				repo.git("remote", "set-url", "origin", repo.Remote+"-missing")
+				repo.write("config.go", "package main\n\nvar loaded bool\n")
+			},
+			code: exitPush,
+			check: func(t *testing.T, repo *testRepo, result runResult) {
+				if result.Commit != repo.git("rev-parse", "HEAD") || result.Pushed || result.PushError == "" {
+					t.Errorf("commit=%q pushed=%v pushError=%q", result.Commit, result.Pushed, result.PushError)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepo(t)
+			server := newFakeModelServer(t, "Added config loader")
+			tt.setup(repo, server)
+
+			code, result := runJSON(t)
+			if code != tt.code || result.ExitCode != tt.code {
+				t.Errorf("exit code = %d, JSON exitCode = %d, want %d (error %q)", code, result.ExitCode, tt.code, result.Error)
+			}
+			tt.check(t, repo, result)
+		})
+	}
+}
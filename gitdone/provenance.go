@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Notes ref the provenance of each commit is kept under, apart from the default notes.
+// Like all notes it is only shared when pushed explicitly.
+const provenanceRef = "refs/notes/gitdone"
+
+// How a commit message was produced
+const (
+	methodModel        = "model"        // Written by the model
+	methodRevert       = "revert"       // An exact undo, described the way git revert would
+	methodFormatting   = "formatting"   // Whitespace-only changes
+	methodDependencies = "dependencies" // A pure dependency bump
+	methodCompletion   = "completion"   // The message git prepared for a merge, cherry-pick or revert
+)
+
+// What gitdone records about a message it wrote, as a JSON note on the commit
+type provenance struct {
+	Command     string  `json:"command"` // commit, amend or reword
+	Method      string  `json:"method"`
+	Backend     string  `json:"backend,omitempty"`
+	Model       string  `json:"model,omitempty"`
+	PromptHash  string  `json:"promptHash,omitempty"`
+	Temperature float64 `json:"temperature,omitempty"`
+	Edited      bool    `json:"edited"`
+	LatencyMs   int64   `json:"latencyMs"`
+}
+
+// Name the backend and model behind a model client
+func modelIdentity(m modelClient) (string, string) {
+	switch m := m.(type) {
+	case *ollamaClient:
+		return "ollama", m.Model
+	case *recordedModel:
+		if m.Live != nil {
+			return modelIdentity(m.Live)
+		}
+		return "recorded", ""
+	default:
+		return fmt.Sprintf("%T", m), ""
+	}
+}
+
+// Short hash of the commit prompt, to tell apart messages written with different prompts
+func commitPromptHash() string {
+	sum := sha256.Sum256([]byte(commitPromptTemplate))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Describe a message produced by a method in the given time. Only messages the
+// model wrote carry the model's details.
+func newProvenance(command, method string, latency time.Duration) provenance {
+	p := provenance{Command: command, Method: method, LatencyMs: latency.Milliseconds()}
+	if method == methodModel {
+		p.Backend, p.Model = modelIdentity(model)
+		p.PromptHash = commitPromptHash()
+		p.Temperature = generateTemperature
+	}
+	return p
+}
+
+// Attach the provenance of a message to its commit, replacing any earlier note
+func recordProvenance(ctx context.Context, sha string, p provenance) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("Error marshaling provenance: %v", err)
+	}
+	if _, err := gitClient.Run(ctx, "notes", "--ref="+provenanceRef, "add", "-f", "-m", string(data), sha); err != nil {
+		return fmt.Errorf("Error writing note on %s: %v", shortSHA(sha), err)
+	}
+	return nil
+}
+
+// Record provenance, warning instead of failing since the commit already exists
+func recordProvenanceQuietly(ctx context.Context, sha string, p provenance) {
+	if err := recordProvenance(ctx, sha, p); err != nil {
+		warn("Could not record how the message was written: %v\n", err)
+	}
+}
+
+// Open a message in the user's git editor and return it as saved, without
+// comment lines. An empty message means the user gave up on the commit.
+func editMessage(ctx context.Context, msg string) (string, error) {
+	dir, err := gitdoneDataDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	editor, err := gitClient.Run(ctx, "var", "GIT_EDITOR")
+	if err != nil {
+		return "", fmt.Errorf("Error finding an editor: %v", err)
+	}
+
+	path := filepath.Join(dir, "COMMIT_EDITMSG")
+	text := msg + "\n\n# Edit the message gitdone generated. Lines starting with '#' are ignored,\n# and an empty message aborts the commit.\n"
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		return "", fmt.Errorf("Error writing %s: %v", path, err)
+	}
+
+	cmd, err := editorCommand(ctx, strings.TrimSpace(editor), path)
+	if err != nil {
+		return "", err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Editor failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Error reading %s: %v", path, err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, strings.TrimRight(line, " \t\r"))
+		}
+	}
+	edited := strings.TrimSpace(strings.Join(lines, "\n"))
+	if edited == "" {
+		return "", fmt.Errorf("Aborting commit due to empty commit message")
+	}
+	return edited, nil
+}
+
+// Build the command that opens path in editor. Like git, the editor runs
+// through the shell so it may carry arguments. Without a shell, as on
+// Windows when Git's sh can't be found, it runs directly, with its
+// arguments split where git's shell would split them.
+func editorCommand(ctx context.Context, editor, path string) (*exec.Cmd, error) {
+	if sh := findShell(); sh != "" {
+		return exec.CommandContext(ctx, sh, "-c", editor+` "$@"`, "editor", path), nil
+	}
+	args := splitCommandLine(editor)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("No editor configured")
+	}
+	return exec.CommandContext(ctx, args[0], append(args[1:], path)...), nil
+}
+
+// Split a command line into words at unquoted whitespace, dropping the
+// quotes. Backslashes are kept as they are, since they separate Windows paths.
+func splitCommandLine(line string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// Acceptance and edit rates of the messages gitdone wrote, from their notes
+type provenanceStats struct {
+	Commits  int            // All commits looked at
+	Noted    int            // Commits with a gitdone note
+	Edited   int            // Of those, messages a human changed before committing
+	Methods  map[string]int // Noted commits by method
+	Models   map[string]int // Model-written commits by backend and model
+	Prompts  map[string]int // Model-written commits by prompt hash
+	Latency  time.Duration  // Total generation time of the noted commits
+	Unparsed int            // Notes that aren't gitdone's JSON
+}
+
+// Gather the provenance notes of the commits in a revision range
+func readProvenanceStats(ctx context.Context, revRange string) (*provenanceStats, error) {
+	// %x1e ends each commit, since a note may span several lines
+	out, err := gitClient.Run(ctx, "log", "--no-notes", "--notes="+provenanceRef, "--format=%N%x1e", revRange)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading notes of %s: %v", revRange, err)
+	}
+
+	stats := &provenanceStats{Methods: map[string]int{}, Models: map[string]int{}, Prompts: map[string]int{}}
+	records := strings.Split(out, "\x1e")
+	for _, record := range records[:len(records)-1] {
+		stats.Commits++
+		note := strings.TrimSpace(record)
+		if note == "" {
+			continue
+		}
+		var p provenance
+		if err := json.Unmarshal([]byte(note), &p); err != nil || p.Method == "" {
+			stats.Unparsed++
+			continue
+		}
+		stats.Noted++
+		stats.Methods[p.Method]++
+		if p.Edited {
+			stats.Edited++
+		}
+		if p.Method == methodModel {
+			name := p.Backend
+			if p.Model != "" {
+				name += "/" + p.Model
+			}
+			stats.Models[name]++
+			stats.Prompts[p.PromptHash]++
+		}
+		stats.Latency += time.Duration(p.LatencyMs) * time.Millisecond
+	}
+	return stats, nil
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
+
+// Print counts by key, most common first
+func printBreakdown(w io.Writer, title string, counts map[string]int, total int) {
+	if len(counts) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	fmt.Fprintf(w, "\n%s\n", title)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %s\t%d\t%.0f%%\n", key, counts[key], percent(counts[key], total))
+	}
+}
+
+func printProvenanceStats(out io.Writer, stats *provenanceStats) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Commits\t%d\n", stats.Commits)
+	fmt.Fprintf(w, "Written by gitdone\t%d\t%.0f%%\n", stats.Noted, percent(stats.Noted, stats.Commits))
+	if stats.Noted > 0 {
+		accepted := stats.Noted - stats.Edited
+		fmt.Fprintf(w, "Accepted as generated\t%d\t%.0f%%\n", accepted, percent(accepted, stats.Noted))
+		fmt.Fprintf(w, "Edited before committing\t%d\t%.0f%%\n", stats.Edited, percent(stats.Edited, stats.Noted))
+		fmt.Fprintf(w, "Average latency\t%v\n", (stats.Latency / time.Duration(stats.Noted)).Round(time.Millisecond))
+	}
+	printBreakdown(w, "By method", stats.Methods, stats.Noted)
+	printBreakdown(w, "By model", stats.Models, stats.Methods[methodModel])
+	printBreakdown(w, "By prompt", stats.Prompts, stats.Methods[methodModel])
+	if stats.Unparsed > 0 {
+		fmt.Fprintf(w, "\nSkipped %d notes not written by gitdone\n", stats.Unparsed)
+	}
+	w.Flush()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Read the provenance note gitdone attached to a commit
+func readNote(t *testing.T, repo *testRepo, rev string) provenance {
+	t.Helper()
+	var p provenance
+	if err := json.Unmarshal([]byte(repo.git("notes", "--ref="+provenanceRef, "show", rev)), &p); err != nil {
+		t.Fatalf("note on %s is not JSON: %v", rev, err)
+	}
+	return p
+}
+
+func TestRunRecordsProvenance(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added greeting helper in hello.go")
+
+	repo.write("hello.go", "package main\n\nfunc hello() string {\n\treturn \"hi\"\n}\n")
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	got := readNote(t, repo, "HEAD")
+	want := provenance{
+		Command:     "commit",
+		Method:      methodModel,
+		Backend:     "ollama",
+		Model:       "test-model",
+		PromptHash:  commitPromptHash(),
+		Temperature: generateTemperature,
+		LatencyMs:   result.Timings["generate"],
+	}
+	if got != want {
+		t.Errorf("note = %+v, want %+v", got, want)
+	}
+	// The note only tells the truth if the temperature reached the model
+	options := server.receivedOptions()
+	if len(options) != 1 || options[0]["temperature"] != generateTemperature {
+		t.Errorf("generate options = %v, want temperature %v", options, generateTemperature)
+	}
+	// The notes stay out of git log unless asked for
+	if out := repo.git("log", "-1"); strings.Contains(out, "promptHash") {
+		t.Errorf("note shown in plain git log:\n%s", out)
+	}
+}
+
+func TestRunRecordsMethodWithoutModel(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Updated nothing")
+
+	repo.write("main.go", "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n")
+	repo.git("add", "-A")
+	repo.git("commit", "-qm", "Added main")
+	repo.write("main.go", "package main\n\nfunc main() {\n        println(\"hi\")\n}\n")
+
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult()); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	if got := readNote(t, repo, "HEAD"); got.Method != methodFormatting || got.Model != "" || got.PromptHash != "" {
+		t.Errorf("note = %+v, want a formatting note without model details", got)
+	}
+}
+
+func TestRunEditRecordsHumanEdit(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added greeting helper in hello.go")
+
+	// An editor that rewrites the subject in place
+	editor := filepath.Join(t.TempDir(), "editor.sh")
+	if err := os.WriteFile(editor, []byte("#!/bin/sh\nsed -i 's/greeting helper/hello function/' \"$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_EDITOR", editor)
+
+	repo.write("hello.go", "package main\n\nfunc hello() string {\n\treturn \"hi\"\n}\n")
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{Edit: true}, newRunResult()); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	if got := repo.git("log", "-1", "--format=%B"); got != "Added hello function in hello.go" {
+		t.Errorf("commit message = %q", got)
+	}
+	if got := readNote(t, repo, "HEAD"); !got.Edited {
+		t.Errorf("note = %+v, want it marked edited", got)
+	}
+}
+
+func TestRunEditAbortsOnEmptyMessage(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added greeting helper in hello.go")
+	t.Setenv("GIT_EDITOR", "sh -c ': > \"$1\"' editor")
+
+	repo.write("hello.go", "package main\n")
+	before := repo.git("rev-parse", "HEAD")
+	err := runGitdone(context.Background(), repo.config(), commitOptions{Edit: true}, newRunResult())
+	if code := exitCode(err); code != exitCommit {
+		t.Errorf("exit code = %d (%v), want %d", code, err, exitCommit)
+	}
+	if got := repo.git("rev-parse", "HEAD"); got != before {
+		t.Errorf("HEAD moved to %s after an empty message", got)
+	}
+}
+
+func TestSplitEditorCommandLine(t *testing.T) {
+	for line, want := range map[string][]string{
+		"vim":         {"vim"},
+		"code --wait": {"code", "--wait"},
+		`"C:\Program Files\Notepad++\notepad++.exe" -multiInst`: {`C:\Program Files\Notepad++\notepad++.exe`, "-multiInst"},
+		"'my editor'  -w ''": {"my editor", "-w", ""},
+	} {
+		if got := splitCommandLine(line); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitCommandLine(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestRewordRecordsProvenance(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Updated the readme")
+
+	repo.write("README", "one\n")
+	repo.git("add", "-A")
+	repo.git("commit", "-qm", "wip")
+	base := repo.git("rev-parse", "HEAD")
+	repo.write("README", "two\n")
+	repo.git("add", "-A")
+	repo.git("commit", "-qm", "wip")
+	repo.git("notes", "--ref="+provenanceRef, "add", "-m", `{"command":"commit","method":"model"}`, "HEAD")
+
+	if _, err := rewordRange(context.Background(), base+"^.."+base, repo.config(), commitOptions{}, false); err != nil {
+		t.Fatalf("rewordRange: %v", err)
+	}
+	if got := readNote(t, repo, "HEAD~1"); got.Command != "reword" || got.Method != methodModel {
+		t.Errorf("reworded note = %+v", got)
+	}
+	// The replayed commit keeps its note
+	if got := readNote(t, repo, "HEAD"); got.Command != "commit" {
+		t.Errorf("replayed note = %+v", got)
+	}
+}
+
+func TestReadProvenanceStats(t *testing.T) {
+	repo := newTestRepo(t)
+	notes := []string{
+		`{"command":"commit","method":"model","backend":"ollama","model":"a","promptHash":"p1","latencyMs":300}`,
+		`{"command":"commit","method":"model","backend":"ollama","model":"a","promptHash":"p1","edited":true,"latencyMs":100}`,
+		`{"command":"commit","method":"dependencies","latencyMs":200}`,
+		"",
+		"left by someone else",
+	}
+	for i, note := range notes {
+		repo.write("file.txt", strings.Repeat("x", i+1))
+		repo.git("add", "-A")
+		repo.git("commit", "-qm", "change")
+		if note != "" {
+			repo.git("notes", "--ref="+provenanceRef, "add", "-m", note, "HEAD")
+		}
+	}
+
+	stats, err := readProvenanceStats(context.Background(), "HEAD~5..HEAD")
+	if err != nil {
+		t.Fatalf("readProvenanceStats: %v", err)
+	}
+	if stats.Commits != 5 || stats.Noted != 3 || stats.Edited != 1 || stats.Unparsed != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.Methods[methodModel] != 2 || stats.Models["ollama/a"] != 2 || stats.Prompts["p1"] != 2 {
+		t.Errorf("breakdown = %v %v %v", stats.Methods, stats.Models, stats.Prompts)
+	}
+
+	var out strings.Builder
+	printProvenanceStats(&out, stats)
+	// Compare without the column padding
+	text := strings.Join(strings.Fields(out.String()), " ")
+	for _, want := range []string{"Written by gitdone 3 60%", "Accepted as generated 2 67%", "Average latency 200ms", "dependencies 1 33%"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
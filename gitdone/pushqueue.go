@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A push that failed because the remote was unreachable, kept for retrying
+type pendingPush struct {
+	Remote    string    `json:"remote"`
+	Branch    string    `json:"branch"`
+	Commit    string    `json:"commit"`
+	Queued    time.Time `json:"queued"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// Push errors that mean the remote couldn't be reached, rather than that it refused the push
+var offlineErrors = []string{
+	"Could not resolve host",
+	"Could not resolve hostname",
+	"Temporary failure in name resolution",
+	"Failed to connect to",
+	"Connection refused",
+	"Connection timed out",
+	"Operation timed out",
+	"Network is unreachable",
+	"No route to host",
+}
+
+// Check whether a push failed because the remote was unreachable
+func isOfflineError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if exitCode(err) == exitTimeout {
+		return true
+	}
+	for _, message := range offlineErrors {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+	return false
+}
+
+func pushQueuePath(ctx context.Context) (string, error) {
+	dir, err := gitdoneDataDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "push-queue.json"), nil
+}
+
+// Load the pending pushes, oldest first
+func loadPushQueue(ctx context.Context) ([]pendingPush, error) {
+	path, err := pushQueuePath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Error reading push queue: %v", err)
+	}
+	var queue []pendingPush
+	if err := json.Unmarshal(data, &queue); err != nil {
+		return nil, fmt.Errorf("Error parsing push queue: %v", err)
+	}
+	return queue, nil
+}
+
+func savePushQueue(ctx context.Context, queue []pendingPush) error {
+	path, err := pushQueuePath(ctx)
+	if err != nil {
+		return err
+	}
+	if len(queue) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error clearing push queue: %v", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(queue, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshaling push queue: %v", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Queue a push for later, replacing any older entry for the same branch
+func queuePush(ctx context.Context, branch, commit string, pushErr error) error {
+	queue, err := loadPushQueue(ctx)
+	if err != nil {
+		return err
+	}
+	entry := pendingPush{Remote: "origin", Branch: branch, Commit: commit, Queued: time.Now(), Attempts: 1, LastError: pushErr.Error()}
+	for i, pending := range queue {
+		if pending.Remote == entry.Remote && pending.Branch == entry.Branch {
+			entry.Queued = pending.Queued
+			entry.Attempts += pending.Attempts
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	return savePushQueue(ctx, append(queue, entry))
+}
+
+// Push every queued branch, keeping the ones that still fail. Stops at the
+// first unreachable remote, since the rest would only time out as well.
+// Returns the number of branches pushed.
+func flushPushQueue(ctx context.Context) (int, error) {
+	queue, err := loadPushQueue(ctx)
+	if err != nil || len(queue) == 0 {
+		return 0, err
+	}
+
+	pushed := 0
+	var remaining []pendingPush
+	for i, pending := range queue {
+		// A commit that was undone or rewritten no longer needs pushing
+		if _, err := gitClient.Run(ctx, "merge-base", "--is-ancestor", pending.Commit, "refs/heads/"+pending.Branch); err != nil {
+			warn("Dropping queued push of %s: %s is no longer on the branch.\n", pending.Branch, shortSHA(pending.Commit))
+			continue
+		}
+
+		_, err := gitClient.Run(ctx, "push", pending.Remote, pending.Branch)
+		if err == nil {
+			success("Pushed queued commits on %s to %s/%s\n", pending.Branch, pending.Remote, pending.Branch)
+			pushed++
+			continue
+		}
+		pending.Attempts++
+		pending.LastError = err.Error()
+		remaining = append(remaining, pending)
+		if isOfflineError(err) || ctx.Err() != nil {
+			remaining = append(remaining, queue[i+1:]...)
+			break
+		}
+		warn("Queued push of %s failed: %v\n", pending.Branch, err)
+	}
+
+	if err := savePushQueue(ctx, remaining); err != nil {
+		return pushed, err
+	}
+	if len(remaining) > 0 {
+		return pushed, fmt.Errorf("%d queued push(es) still pending", len(remaining))
+	}
+	return pushed, nil
+}
+
+// Retry queued pushes at the start of a run; failures only warn
+func flushPushQueueQuietly(ctx context.Context) {
+	queue, err := loadPushQueue(ctx)
+	if err != nil {
+		warn("Skipping queued pushes: %v\n", err)
+		return
+	}
+	if len(queue) == 0 {
+		return
+	}
+	info("Retrying %d queued push(es)...\n", len(queue))
+	if _, err := flushPushQueue(ctx); err != nil {
+		warn("%v; retry with gitdone push --pending.\n", err)
+	}
+}
+
+// A local branch and how far it is ahead of its upstream
+type branchStatus struct {
+	Branch   string
+	Upstream string
+	Ahead    int
+	Queued   *pendingPush
+}
+
+// List local branches with commits that are not pushed yet
+func unpushedBranches(ctx context.Context) ([]branchStatus, error) {
+	out, err := gitClient.Run(ctx, "for-each-ref", "--format=%(refname:short)\t%(upstream:short)", "refs/heads")
+	if err != nil {
+		return nil, fmt.Errorf("Error listing branches: %v", err)
+	}
+	queue, err := loadPushQueue(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []branchStatus
+	// Branches without an upstream end in a tab, so only trim newlines
+	for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 || fields[0] == "" {
+			continue
+		}
+		status := branchStatus{Branch: fields[0], Upstream: fields[1]}
+		for i := range queue {
+			if queue[i].Branch == status.Branch {
+				status.Queued = &queue[i]
+			}
+		}
+
+		// Without an upstream, count the commits on no remote branch at all
+		rangeArgs := []string{status.Branch, "--not", "--remotes"}
+		if status.Upstream != "" {
+			rangeArgs = []string{status.Upstream + ".." + status.Branch}
+		}
+		count, err := gitClient.Run(ctx, append([]string{"rev-list", "--count"}, rangeArgs...)...)
+		if err != nil {
+			return nil, fmt.Errorf("Error counting unpushed commits on %s: %v", status.Branch, err)
+		}
+		fmt.Sscanf(strings.TrimSpace(count), "%d", &status.Ahead)
+		if status.Ahead > 0 || status.Queued != nil {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
+
+// Print which branches are committed but not yet pushed
+func printPushStatus(ctx context.Context) error {
+	statuses, err := unpushedBranches(ctx)
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		success("Everything is pushed.\n")
+		return nil
+	}
+	for _, status := range statuses {
+		upstream := status.Upstream
+		if upstream == "" {
+			upstream = "no upstream"
+		}
+		fmt.Fprintf(os.Stdout, "%-24s %d unpushed commit(s) (%s)\n", status.Branch, status.Ahead, upstream)
+		if status.Queued != nil {
+			fmt.Fprintf(os.Stdout, "%-24s queued %s, %d attempt(s): %s\n", "",
+				status.Queued.Queued.Format("2006-01-02 15:04"), status.Queued.Attempts, lastLine(status.Queued.LastError))
+		}
+	}
+	return nil
+}
+
+// Get the last non-empty line of a multi-line error message
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestUnreachableRemoteQueuesPush(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added retry budget")
+	ctx := context.Background()
+
+	// Nothing listens on port 1, so the push can't connect
+	repo.git("remote", "set-url", "origin", "http://127.0.0.1:1/repo.git")
+	repo.write("retry.go", "package main\n\nconst budget = 3\n")
+
+	result := newRunResult()
+	err := runGitdone(ctx, repo.config(), commitOptions{}, result)
+	if exitCode(err) != exitPush {
+		t.Fatalf("err = %v (exit %d), want push failure", err, exitCode(err))
+	}
+	if !result.Queued {
+		t.Fatalf("push was not queued: %s", result.PushError)
+	}
+
+	queue, err := loadPushQueue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0].Branch != "main" || queue[0].Commit != result.Commit {
+		t.Fatalf("queue = %+v", queue)
+	}
+	statuses, err := unpushedBranches(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Branch != "main" || statuses[0].Ahead != 1 || statuses[0].Queued == nil {
+		t.Errorf("status = %+v", statuses)
+	}
+
+	// Back online, the next run pushes the queued commit before its own
+	repo.git("remote", "set-url", "origin", repo.Remote)
+	repo.write("retry.go", "package main\n\nconst budget = 5\n")
+	result = newRunResult()
+	if err := runGitdone(ctx, repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	if queue, _ := loadPushQueue(ctx); len(queue) != 0 {
+		t.Errorf("queue not flushed: %+v", queue)
+	}
+	if remote := strings.TrimSpace(runGitIn(t, repo.Remote, "rev-parse", "main")); remote != result.Commit {
+		t.Errorf("remote main = %s, want %s", remote, result.Commit)
+	}
+}
+
+func TestFlushDropsUndoneCommits(t *testing.T) {
+	repo := newTestRepo(t)
+	ctx := context.Background()
+
+	repo.write("draft.go", "package main\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added draft")
+	commit := repo.git("rev-parse", "HEAD")
+	if err := queuePush(ctx, "main", commit, context.DeadlineExceeded); err != nil {
+		t.Fatal(err)
+	}
+	repo.git("reset", "-q", "--hard", "HEAD~1")
+
+	pushed, err := flushPushQueue(ctx)
+	if err != nil || pushed != 0 {
+		t.Errorf("flush = %d, %v; want nothing pushed", pushed, err)
+	}
+	if queue, _ := loadPushQueue(ctx); len(queue) != 0 {
+		t.Errorf("queue = %+v, want the undone commit dropped", queue)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// How many recent commits a change is checked against for being their exact undo
+const revertLookback = 20
+
+// A commit that a change undoes exactly
+type revertedCommit struct {
+	SHA     string
+	Subject string
+}
+
+// Get the stable patch IDs of the patches a git command prints, mapped to the
+// commit each belongs to (all zeros for a plain diff). The output is piped to
+// git patch-id as it is produced, so it is never held in memory.
+func patchIDs(ctx context.Context, args ...string) (map[string]string, error) {
+	var out string
+	err := gitClient.Stream(ctx, func(r io.Reader) (err error) {
+		out, err = gitClient.RunInput(ctx, r, "patch-id", "--stable")
+		return err
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		id, sha, ok := strings.Cut(line, " ")
+		// The newest commit wins when two have the same patch
+		if _, seen := ids[id]; ok && !seen {
+			ids[id] = sha
+		}
+	}
+	return ids, nil
+}
+
+// Find the recent commit that a change exactly undoes, by comparing the
+// change's patch ID with those of the reversed patches of the commits before
+// it. Returns nil if there is none, or no history to compare with.
+func findRevertedCommit(ctx context.Context, source diffSource) (*revertedCommit, error) {
+	// Without prefixes, because log -R swaps a/ and b/ and the patch ID covers them
+	diffArgs := append([]string{"diff", "--no-color", "--no-ext-diff", "--no-renames", "--no-prefix"}, source.diffArgs()...)
+	change, err := patchIDs(ctx, diffArgs...)
+	if err != nil || len(change) != 1 {
+		return nil, err
+	}
+
+	// log -R prints each commit's patch reversed, which is what undoing it looks like
+	recent, err := patchIDs(ctx, "log", "-p", "-R", "--no-color", "--no-ext-diff", "--no-renames", "--no-prefix", "--no-merges",
+		fmt.Sprintf("--max-count=%d", revertLookback), source.Before)
+	if err != nil {
+		// Before the first commit there is nothing to revert
+		return nil, nil
+	}
+
+	for id := range change {
+		sha, ok := recent[id]
+		if !ok {
+			return nil, nil
+		}
+		subject, err := gitClient.Run(ctx, "log", "-1", "--format=%s", sha)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading message of %s: %v", shortSHA(sha), err)
+		}
+		return &revertedCommit{SHA: sha, Subject: strings.TrimSpace(subject)}, nil
+	}
+	return nil, nil
+}
+
+// The message git revert would write
+func revertMessage(reverted *revertedCommit) string {
+	return fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.", reverted.Subject, reverted.SHA)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExactUndoBecomesRevert(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Restored the old limit")
+	repo.write("config.go", "package main\n\nconst limit = 3\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added limit")
+	repo.write("config.go", "package main\n\nconst limit = 5\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Raised limit to 5")
+	raised := repo.git("rev-parse", "HEAD")
+	repo.write("notes.txt", "unrelated\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added notes")
+
+	repo.write("config.go", "package main\n\nconst limit = 3\n")
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult()); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	want := "Revert \"Raised limit to 5\"\n\nThis reverts commit " + raised + "."
+	if got := repo.git("log", "-1", "--format=%B"); got != want {
+		t.Errorf("commit message = %q, want %q", got, want)
+	}
+	if prompts := server.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("the model was asked about a revert: %q", prompts)
+	}
+}
+
+func TestRevertKeepsBodyWithSubjectTicket(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Restored the old limit")
+	repo.write(".gitdone.json", `{"ticketPlacement": "subject"}`)
+	repo.write("config.go", "package main\n\nconst limit = 3\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added limit")
+	repo.write("config.go", "package main\n\nconst limit = 5\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Raised limit to 5")
+	raised := repo.git("rev-parse", "HEAD")
+
+	repo.git("checkout", "-b", "PROJ-12-restore-limit")
+	repo.write("config.go", "package main\n\nconst limit = 3\n")
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult()); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	want := "PROJ-12: Revert \"Raised limit to 5\"\n\nThis reverts commit " + raised + "."
+	if got := repo.git("log", "-1", "--format=%B"); got != want {
+		t.Errorf("commit message = %q, want %q", got, want)
+	}
+}
+
+func TestPartialUndoIsNotRevert(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Restored the old limit")
+	repo.write("config.go", "package main\n\nconst limit = 3\n")
+	repo.write("notes.txt", "first\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added limit")
+	repo.write("config.go", "package main\n\nconst limit = 5\n")
+	repo.write("notes.txt", "second\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Raised limit and updated notes")
+
+	repo.write("config.go", "package main\n\nconst limit = 3\n")
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult()); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+	if got := repo.git("log", "-1", "--format=%s"); got != "Restored the old limit" {
+		t.Errorf("commit subject = %q, want the model's message", got)
+	}
+}
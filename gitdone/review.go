@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Changed lines of a file shown to the model; the rest of a large change goes unreviewed
+const maxReviewLines = 400
+
+// Severities a review finding can have, most severe first
+var reviewSeverities = []string{"high", "medium", "low"}
+
+// A problem the review found, at a line of the new version of a file
+type reviewFinding struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (f reviewFinding) String() string {
+	return fmt.Sprintf("%s:%d [%s] %s", f.Path, f.Line, f.Severity, f.Message)
+}
+
+var (
+	hunkNewStart = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)`)
+	// "12: high: message", tolerating list markers, "line" and other separators
+	findingLine = regexp.MustCompile(`(?i)^[\s*-]*(?:line\s*)?(\d+)\s*[:|,-]\s*\[?(high|medium|low)\]?\s*[:|,-]\s*(.+)$`)
+)
+
+// Number the lines of a file's hunks as they are in the new version, so the
+// model can point at them. Removed lines get no number. Also returns the
+// numbers of the added lines.
+func numberedHunks(file *selectableFile) (string, []int) {
+	var out strings.Builder
+	var added []int
+	for _, hunk := range file.Hunks {
+		line := 1
+		if m := hunkNewStart.FindStringSubmatch(hunk.Header); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		out.WriteString(hunk.Header + "\n")
+		for _, text := range hunk.Lines {
+			switch {
+			case strings.HasPrefix(text, "+"):
+				fmt.Fprintf(&out, "%5d %s\n", line, text)
+				added = append(added, line)
+				line++
+			case strings.HasPrefix(text, "-"):
+				fmt.Fprintf(&out, "%5s %s\n", "", text)
+			case strings.HasPrefix(text, `\`):
+				// "\ No newline at end of file"
+			default:
+				fmt.Fprintf(&out, "%5d %s\n", line, text)
+				line++
+			}
+		}
+	}
+	return out.String(), added
+}
+
+// Parse the model's findings for a file. Findings at lines the diff didn't
+// add are moved to the nearest added line, since only those were reviewed.
+func parseFindings(path, response string, added []int) []reviewFinding {
+	var findings []reviewFinding
+	for _, line := range strings.Split(response, "\n") {
+		m := findingLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(m[1])
+		findings = append(findings, reviewFinding{
+			Path:     path,
+			Line:     nearestLine(added, number),
+			Severity: strings.ToLower(m[2]),
+			Message:  strings.TrimSpace(m[3]),
+		})
+	}
+	return findings
+}
+
+// Find the line in lines closest to line
+func nearestLine(lines []int, line int) int {
+	nearest := line
+	for i, candidate := range lines {
+		if i == 0 || abs(candidate-line) < abs(nearest-line) {
+			nearest = candidate
+		}
+	}
+	return nearest
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Review one file's changes with the model
+func reviewFile(ctx context.Context, file *selectableFile) ([]reviewFinding, error) {
+	hunks, added := numberedHunks(file)
+	if len(added) == 0 || generatedByName(file.Path) != "" {
+		return nil, nil
+	}
+
+	if file.Truncated {
+		info("Reviewing only the first %d changed lines of %s.\n", maxReviewLines, file.Path)
+		hunks += "(The rest of the change is left out.)\n"
+	}
+
+	prompt := fmt.Sprintf(`Review this change to %s before it is committed.
+Look for obvious bugs, leftover debug prints, TODO or FIXME comments, ignored or unchecked errors, and code that needs tests but has none.
+Only report problems in the added lines (marked +). Each line is prefixed with its line number in the new file.
+
+Answer with one finding per line in exactly this form, and nothing else:
+<line number>: <high|medium|low>: <problem in a few words>
+
+Use high only for bugs that would break things. If there is nothing to report, answer "None".
+
+%s`, file.Path, hunks)
+
+	response, err := model.Generate(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("Error reviewing %s: %v", file.Path, err)
+	}
+	return parseFindings(file.Path, response, added), nil
+}
+
+// Review a diff file by file as it is read, returning the findings most severe
+// first. Each file is reviewed before the next is read, and at most
+// maxReviewLines of its changed lines are kept.
+func reviewDiff(ctx context.Context, r io.Reader) ([]reviewFinding, error) {
+	var findings []reviewFinding
+	err := readSelectableFiles(r, maxReviewLines, func(file *selectableFile) error {
+		info("Reviewing %s...\n", file.Path)
+		fileFindings, err := reviewFile(ctx, file)
+		findings = append(findings, fileFindings...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rank := func(severity string) int {
+		for i, s := range reviewSeverities {
+			if s == severity {
+				return i
+			}
+		}
+		return len(reviewSeverities)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if a, b := rank(findings[i].Severity), rank(findings[j].Severity); a != b {
+			return a < b
+		}
+		if findings[i].Path != findings[j].Path {
+			return findings[i].Path < findings[j].Path
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings, nil
+}
+
+// Count the findings of high severity
+func highSeverity(findings []reviewFinding) int {
+	count := 0
+	for _, finding := range findings {
+		if finding.Severity == "high" {
+			count++
+		}
+	}
+	return count
+}
+
+// Print findings one per line, as path:line [severity] message
+func printFindings(out io.Writer, findings []reviewFinding) {
+	if len(findings) == 0 {
+		success("The review found no problems.\n")
+		return
+	}
+	for _, finding := range findings {
+		fmt.Fprintln(out, finding)
+	}
+	info("The review found %d problem(s), %d of high severity.\n", len(findings), highSeverity(findings))
+}
+
+// Review the staged diff as git prints it
+func reviewStagedDiff(ctx context.Context) ([]reviewFinding, error) {
+	var findings []reviewFinding
+	err := gitClient.Stream(ctx, func(r io.Reader) (err error) {
+		findings, err = reviewDiff(ctx, r)
+		return err
+	}, "diff", "--cached", "--no-color", "--no-ext-diff", "-M", "--submodule=short")
+	return findings, err
+}
+
+// Review the staged changes before they are committed, failing on high-severity findings
+func reviewStaged(ctx context.Context, result *runResult) error {
+	findings, err := reviewStagedDiff(ctx)
+	if err != nil {
+		return failPhase(exitGenerate, err)
+	}
+	result.Findings = findings
+	printFindings(color.Output, findings)
+	if high := highSeverity(findings); high > 0 {
+		return failPhase(exitReview, fmt.Errorf("The review found %d high-severity problem(s); fix them or commit without --review", high))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFindingsUsesHunkLines(t *testing.T) {
+	diff := "diff --git a/main.go b/main.go\n--- a/main.go\n+++ b/main.go\n" +
+		"@@ -10,3 +10,4 @@ func main() {\n \tx := load()\n-\tuse(x)\n+\tfmt.Println(\"debug\", x)\n+\tuse(x) // TODO check err\n \treturn\n"
+	file := parseSelectableDiff(diff)[0]
+
+	numbered, added := numberedHunks(file)
+	if !reflect.DeepEqual(added, []int{11, 12}) {
+		t.Errorf("added lines = %v, want [11 12]", added)
+	}
+	if !strings.Contains(numbered, "   11 +\tfmt.Println") || !strings.Contains(numbered, "   13  \treturn") {
+		t.Errorf("numbered hunks:\n%s", numbered)
+	}
+
+	response := "- Line 11: medium: leftover debug print\n12 | HIGH | unchecked error\n40: low: far away\nNone of the rest"
+	want := []reviewFinding{
+		{Path: "main.go", Line: 11, Severity: "medium", Message: "leftover debug print"},
+		{Path: "main.go", Line: 12, Severity: "high", Message: "unchecked error"},
+		{Path: "main.go", Line: 12, Severity: "low", Message: "far away"},
+	}
+	if got := parseFindings("main.go", response, added); !reflect.DeepEqual(got, want) {
+		t.Errorf("findings = %+v, want %+v", got, want)
+	}
+}
+
+func TestReviewBlocksOnHighSeverity(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "3: high: dereferences a nil map")
+	head := repo.git("rev-parse", "HEAD")
+	index := repo.git("write-tree")
+	repo.write("cache.go", "package main\n\nvar cache map[string]int\n\nfunc init() { cache[\"a\"] = 1 }\n")
+
+	result := newRunResult()
+	err := runGitdone(context.Background(), repo.config(), commitOptions{Review: true}, result)
+	if exitCode(err) != exitReview {
+		t.Fatalf("err = %v (exit %d), want review block", err, exitCode(err))
+	}
+	if got := repo.git("rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD moved to %s after a blocking review", got)
+	}
+	if got := repo.git("write-tree"); got != index {
+		t.Errorf("index not restored after a blocking review, status:\n%s", repo.git("status", "--porcelain"))
+	}
+	want := []reviewFinding{{Path: "cache.go", Line: 3, Severity: "high", Message: "dereferences a nil map"}}
+	if !reflect.DeepEqual(result.Findings, want) {
+		t.Errorf("findings = %+v, want %+v", result.Findings, want)
+	}
+	if prompts := server.receivedPrompts(); len(prompts) != 1 || !strings.Contains(prompts[0], "    3 +var cache") {
+		t.Errorf("want one numbered review prompt, got %q", prompts)
+	}
+}
+
+func TestReviewCapsLinesPerFile(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "None")
+	var big strings.Builder
+	for i := 1; i <= 3*maxReviewLines; i++ {
+		fmt.Fprintf(&big, "var v%d = %d\n", i, i)
+	}
+	repo.write("big.go", "package main\n\n"+big.String())
+	repo.write("small.go", "package main\n\nvar small = 1\n")
+	repo.git("add", ".")
+
+	if _, err := reviewStagedDiff(context.Background()); err != nil {
+		t.Fatalf("reviewStagedDiff: %v", err)
+	}
+	prompts := server.receivedPrompts()
+	if len(prompts) != 2 {
+		t.Fatalf("got %d review prompts, want one per file", len(prompts))
+	}
+	if !strings.Contains(prompts[0], fmt.Sprintf("var v%d =", maxReviewLines-2)) || strings.Contains(prompts[0], fmt.Sprintf("var v%d =", maxReviewLines)) {
+		t.Errorf("big.go prompt is not cut at %d lines", maxReviewLines)
+	}
+	if !strings.Contains(prompts[0], "The rest of the change is left out") {
+		t.Errorf("big.go prompt does not say it was cut short")
+	}
+	if !strings.Contains(prompts[1], "var small = 1") {
+		t.Errorf("small.go was not reviewed after big.go:\n%s", prompts[1])
+	}
+}
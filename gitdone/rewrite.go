@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Get the remote-tracking branch commits are pushed to: the configured
+// upstream, or origin/<branch> where gitdone pushes. Empty if there is none.
+func upstreamRef(ctx context.Context) (string, error) {
+	if upstream, err := gitClient.Run(ctx, "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{u}"); err == nil {
+		return strings.TrimSpace(upstream), nil
+	}
+
+	branch, err := currentBranch(ctx)
+	if err != nil {
+		return "", err
+	}
+	if _, err := gitClient.Run(ctx, "rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+branch); err != nil {
+		return "", nil
+	}
+	return "origin/" + branch, nil
+}
+
+// Check whether a commit is already on the upstream branch
+func isPublished(ctx context.Context, sha, upstream string) (bool, error) {
+	if upstream == "" {
+		return false, nil
+	}
+	branches, err := gitClient.Run(ctx, "branch", "-r", "--contains", sha)
+	if err != nil {
+		return false, fmt.Errorf("Error checking whether %s is pushed: %v", shortSHA(sha), err)
+	}
+	for _, branch := range strings.Split(branches, "\n") {
+		if strings.TrimSpace(branch) == upstream {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Refuse to rewrite commits that are already pushed unless forced
+func checkUnpublished(ctx context.Context, shas []string, force bool) error {
+	if force {
+		return nil
+	}
+	upstream, err := upstreamRef(ctx)
+	if err != nil {
+		return err
+	}
+	for _, sha := range shas {
+		published, err := isPublished(ctx, sha, upstream)
+		if err != nil {
+			return err
+		}
+		if published {
+			return fmt.Errorf("Commit %s is already on %s; use --force to rewrite it anyway", shortSHA(sha), upstream)
+		}
+	}
+	return nil
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
+// Get the parents of a commit
+func commitParents(ctx context.Context, sha string) ([]string, error) {
+	line, err := gitClient.Run(ctx, "rev-list", "--parents", "-n", "1", sha)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading parents of %s: %v", shortSHA(sha), err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Unknown commit %s", sha)
+	}
+	return fields[1:], nil
+}
+
+// Split the trailer block off the end of a commit message
+func splitTrailers(msg string) (string, []string) {
+	msg = strings.TrimRight(msg, "\n")
+	paragraphs := strings.Split(msg, "\n\n")
+	if len(paragraphs) < 2 {
+		return msg, nil
+	}
+	lines := strings.Split(paragraphs[len(paragraphs)-1], "\n")
+	for _, line := range lines {
+		if !trailerLine.MatchString(line) {
+			return msg, nil
+		}
+	}
+	return strings.Join(paragraphs[:len(paragraphs)-1], "\n\n"), lines
+}
+
+// Generate a fresh message for an existing commit, keeping its trailers. Also
+// returns its provenance, or nil if the commit kept its old message. The
+// commits being rewritten are passed as exclude, so their old messages aren't
+// offered to the model as examples.
+func regenerateMessage(ctx context.Context, command, sha string, exclude []string, cfg *config) (string, *provenance, error) {
+	start := time.Now()
+	files, err := streamDiffChanges(ctx, "show", "--format=", "--no-color", "-M", "--submodule=short", sha)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error reading diff of %s: %v", shortSHA(sha), err)
+	}
+	oldMsg, err := gitClient.Run(ctx, "log", "-1", "--format=%B", sha)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error reading message of %s: %v", shortSHA(sha), err)
+	}
+
+	// A commit with an empty diff keeps its message
+	if len(files) == 0 {
+		return strings.TrimSpace(oldMsg), nil, nil
+	}
+
+	modules, changeSummary, err := summarizeFiles(ctx, files, commitChanges(sha), cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	newMsg, method, err := draftCommitMessage(ctx, changeSummary, modules, commitChanges(sha), exclude, cfg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	_, trailers := splitTrailers(oldMsg)
+	note := newProvenance(command, method, time.Since(start))
+	return appendTrailers(newMsg, trailers), &note, nil
+}
+
+// Regenerate the message of HEAD from its diff
+func amendHead(ctx context.Context, cfg *config, opts commitOptions, force bool) (string, error) {
+	if err := refuseInProgress(ctx); err != nil {
+		return "", err
+	}
+	head, err := gitClient.Run(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("Error reading HEAD: %v", err)
+	}
+	head = strings.TrimSpace(head)
+
+	parents, err := commitParents(ctx, head)
+	if err != nil {
+		return "", err
+	}
+	if len(parents) > 1 {
+		return "", fmt.Errorf("HEAD is a merge commit; gitdone amend only rewrites regular commits")
+	}
+	if err := checkUnpublished(ctx, []string{head}, force); err != nil {
+		return "", err
+	}
+
+	msg, note, err := regenerateMessage(ctx, "amend", head, []string{head}, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	// --only leaves anything already staged out of the amended commit
+	args := append([]string{"commit", "--amend", "--only", "-m", msg}, signingArgs(opts)...)
+	if _, err := gitClient.Run(ctx, args...); err != nil {
+		return "", fmt.Errorf("Error amending commit: %v", err)
+	}
+	success("Amended %s with message:\n%s\n", shortSHA(head), msg)
+	amended, err := gitClient.Run(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("Error reading amended commit: %v", err)
+	}
+	amended = strings.TrimSpace(amended)
+
+	// The amended commit replaces one that may have been signed
+	if signingEnabled(ctx, opts) {
+		if err := verifyCommitSignature(ctx, amended); err != nil {
+			return "", err
+		}
+	}
+	if note != nil {
+		recordProvenanceQuietly(ctx, amended, *note)
+	}
+	if err := forgetHistory(ctx, []string{head}); err != nil {
+		warn("Could not update the history index: %v\n", err)
+	}
+	return msg, nil
+}
+
+// Environment that keeps a commit's original author when recreating it
+func authorEnv(ctx context.Context, sha string) ([]string, error) {
+	author, err := gitClient.Run(ctx, "log", "-1", "--format=%an%x00%ae%x00%ad", "--date=raw", sha)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading author of %s: %v", shortSHA(sha), err)
+	}
+	parts := strings.Split(strings.TrimRight(author, "\n"), "\x00")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Unexpected author format for %s", shortSHA(sha))
+	}
+	return []string{
+		"GIT_AUTHOR_NAME=" + parts[0],
+		"GIT_AUTHOR_EMAIL=" + parts[1],
+		"GIT_AUTHOR_DATE=" + parts[2],
+	}, nil
+}
+
+// Recreate a commit on a new parent with the given message, signing it if sign is set.
+// git commit-tree ignores commit.gpgsign, so the caller resolves signing up front.
+func recreateCommit(ctx context.Context, sha, parent, msg string, sign bool) (string, error) {
+	env, err := authorEnv(ctx, sha)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"commit-tree", sha + "^{tree}", "-m", msg}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	if sign {
+		args = append(args, "-S")
+	}
+	newSHA, err := gitClient.RunEnv(ctx, env, args...)
+	if err != nil {
+		return "", fmt.Errorf("Error recreating %s: %v", shortSHA(sha), err)
+	}
+	newSHA = strings.TrimSpace(newSHA)
+
+	if sign {
+		if err := verifyCommitSignature(ctx, newSHA); err != nil {
+			return "", err
+		}
+	}
+	return newSHA, nil
+}
+
+// Rewrite the messages of the commits in a range without an interactive rebase.
+// A single revision means everything after it up to HEAD.
+func rewordRange(ctx context.Context, revRange string, cfg *config, opts commitOptions, force bool) (int, error) {
+	if err := refuseInProgress(ctx); err != nil {
+		return 0, err
+	}
+	if !strings.Contains(revRange, "..") {
+		revRange += "..HEAD"
+	}
+	end := strings.SplitN(revRange, "..", 2)[1]
+	if end == "" {
+		end = "HEAD"
+	}
+
+	branchRef, err := gitClient.Run(ctx, "symbolic-ref", "-q", "HEAD")
+	if err != nil {
+		return 0, fmt.Errorf("HEAD is detached; check out a branch before rewording")
+	}
+	branchRef = strings.TrimSpace(branchRef)
+	head, err := gitClient.Run(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return 0, fmt.Errorf("Error reading HEAD: %v", err)
+	}
+	head = strings.TrimSpace(head)
+
+	revs, err := gitClient.Run(ctx, "rev-list", "--reverse", "--topo-order", revRange)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid range %s: %v", revRange, err)
+	}
+	targets := strings.Fields(revs)
+	if len(targets) == 0 {
+		return 0, fmt.Errorf("Range %s contains no commits", revRange)
+	}
+
+	// Commits between the end of the range and HEAD are replayed unchanged
+	after, err := gitClient.Run(ctx, "rev-list", "--reverse", "--topo-order", end+"..HEAD")
+	if err != nil {
+		return 0, fmt.Errorf("Error listing commits after %s: %v", end, err)
+	}
+	if _, err := gitClient.Run(ctx, "merge-base", "--is-ancestor", end, "HEAD"); err != nil {
+		return 0, fmt.Errorf("%s is not part of the current branch", end)
+	}
+	replay := strings.Fields(after)
+
+	all := append(append([]string{}, targets...), replay...)
+	if err := checkUnpublished(ctx, all, force); err != nil {
+		return 0, err
+	}
+
+	sign := signingEnabled(ctx, opts)
+	rewritten := make(map[string]string, len(all))
+	reword := make(map[string]bool, len(targets))
+	for _, sha := range targets {
+		reword[sha] = true
+	}
+
+	for _, sha := range all {
+		parents, err := commitParents(ctx, sha)
+		if err != nil {
+			return 0, err
+		}
+		if len(parents) > 1 {
+			return 0, fmt.Errorf("Commit %s is a merge; gitdone reword cannot rewrite merges", shortSHA(sha))
+		}
+
+		parent := ""
+		if len(parents) == 1 {
+			parent = parents[0]
+			if newParent, ok := rewritten[parent]; ok {
+				parent = newParent
+			}
+		}
+
+		var msg string
+		var note *provenance
+		if reword[sha] {
+			info("Rewording %s...\n", shortSHA(sha))
+			msg, note, err = regenerateMessage(ctx, "reword", sha, all, cfg)
+		} else {
+			msg, err = gitClient.Run(ctx, "log", "-1", "--format=%B", sha)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		newSHA, err := recreateCommit(ctx, sha, parent, msg, sign)
+		if err != nil {
+			return 0, err
+		}
+		rewritten[sha] = newSHA
+		if note != nil {
+			recordProvenanceQuietly(ctx, newSHA, *note)
+		} else {
+			// Replayed commits keep their notes; most have none to copy
+			gitClient.Run(ctx, "notes", "--ref="+provenanceRef, "copy", sha, newSHA)
+		}
+		if reword[sha] {
+			success("%s -> %s %s\n", shortSHA(sha), shortSHA(newSHA), strings.SplitN(msg, "\n", 2)[0])
+		}
+	}
+
+	// Only move the branch if nobody else moved it meanwhile
+	newHead := rewritten[all[len(all)-1]]
+	if _, err := gitClient.Run(ctx, "update-ref", "-m", "gitdone reword "+revRange, branchRef, newHead, head); err != nil {
+		return 0, fmt.Errorf("Error updating %s: %v", branchRef, err)
+	}
+	if err := forgetHistory(ctx, all); err != nil {
+		warn("Could not update the history index: %v\n", err)
+	}
+	return len(targets), nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAmendRegeneratesHeadMessage(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added cache invalidation hook")
+
+	repo.write("cache.go", "package main\n\nfunc invalidate() {}\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "wip\n\nSigned-off-by: Test User <test@example.com>")
+	// Staged changes must stay out of the amended commit
+	repo.write("other.go", "package main\n")
+	repo.git("add", "other.go")
+
+	if _, err := amendHead(context.Background(), repo.config(), commitOptions{}, false); err != nil {
+		t.Fatalf("amendHead: %v", err)
+	}
+
+	want := "Added cache invalidation hook\n\nSigned-off-by: Test User <test@example.com>"
+	if got := repo.git("log", "-1", "--format=%B"); got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+	if got := repo.git("diff-tree", "--no-commit-id", "--name-only", "-r", "HEAD"); got != "cache.go" {
+		t.Errorf("amended commit files = %q", got)
+	}
+	if got := repo.git("diff", "--cached", "--name-only"); got != "other.go" {
+		t.Errorf("staged files after amend = %q", got)
+	}
+}
+
+func TestAmendRefusesPushedCommit(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added nothing")
+	repo.git("fetch", "origin")
+
+	_, err := amendHead(context.Background(), repo.config(), commitOptions{}, false)
+	if err == nil || !strings.Contains(err.Error(), "already on origin/main") {
+		t.Fatalf("err = %v, want refusal", err)
+	}
+	if _, err := amendHead(context.Background(), repo.config(), commitOptions{}, true); err != nil {
+		t.Fatalf("forced amend: %v", err)
+	}
+}
+
+func TestRewordRange(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added generated message")
+
+	base := repo.git("rev-parse", "HEAD")
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		repo.write(name, "package main\n")
+		repo.git("add", name)
+		repo.git("commit", "-m", "wip "+name)
+	}
+	tree := repo.git("rev-parse", "HEAD^{tree}")
+
+	count, err := rewordRange(context.Background(), "HEAD~3..HEAD~1", repo.config(), commitOptions{}, false)
+	if err != nil {
+		t.Fatalf("rewordRange: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("reworded %d commits, want 2", count)
+	}
+
+	want := "wip c.go\nAdded generated message\nAdded generated message"
+	if got := repo.git("log", "--format=%s", base+"..HEAD"); got != want {
+		t.Errorf("subjects:\n%s\nwant:\n%s", got, want)
+	}
+	if got := repo.git("rev-parse", "HEAD^{tree}"); got != tree {
+		t.Errorf("tree changed from %s to %s", tree, got)
+	}
+	if got := repo.git("log", "-1", "--format=%an", "HEAD~1"); got != "Test User" {
+		t.Errorf("author = %q", got)
+	}
+}
+
+func TestRewordRefusesPushedCommits(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added generated message")
+	repo.git("fetch", "origin")
+	repo.write("a.go", "package main\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "wip")
+	head := repo.git("rev-parse", "HEAD")
+
+	if _, err := rewordRange(context.Background(), "HEAD~2", repo.config(), commitOptions{}, false); err == nil {
+		t.Fatal("rewordRange rewrote a pushed commit")
+	}
+	if got := repo.git("rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD moved to %s after refusal", got)
+	}
+}
+
+// Turn on SSH signing through git config alone, the way a user's setup would
+func useSSHSigning(t *testing.T, repo *testRepo) {
+	t.Helper()
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	key := filepath.Join(t.TempDir(), "key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "test", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen: %v\n%s", err, out)
+	}
+	public, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := filepath.Join(t.TempDir(), "allowed_signers")
+	if err := os.WriteFile(signers, append([]byte("test@example.com "), public...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo.git("config", "gpg.format", "ssh")
+	repo.git("config", "user.signingkey", key)
+	repo.git("config", "gpg.ssh.allowedSignersFile", signers)
+	repo.git("config", "commit.gpgsign", "true")
+}
+
+func TestRewriteKeepsConfiguredSigning(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Added generated message")
+	useSSHSigning(t, repo)
+
+	base := repo.git("rev-parse", "HEAD")
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		repo.write(name, "package main\n")
+		repo.git("add", name)
+		repo.git("commit", "-m", "wip "+name)
+	}
+
+	// Reword the first two; the third is replayed and must be signed as well
+	if _, err := rewordRange(context.Background(), base+"..HEAD~1", repo.config(), commitOptions{}, false); err != nil {
+		t.Fatalf("rewordRange: %v", err)
+	}
+	if got := repo.git("log", "--format=%G?", base+"..HEAD"); got != "G\nG\nG" {
+		t.Errorf("signature status after reword = %q, want all good", got)
+	}
+
+	if _, err := amendHead(context.Background(), repo.config(), commitOptions{}, false); err != nil {
+		t.Fatalf("amendHead: %v", err)
+	}
+	if got := repo.git("log", "-1", "--format=%G?"); got != "G" {
+		t.Errorf("signature status after amend = %q, want G", got)
+	}
+}
+
+func TestRewriteLeavesOldMessagesOutOfExamples(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Added cache invalidation hook")
+
+	base := repo.git("rev-parse", "HEAD")
+	repo.write("cache.go", "package main\n\nfunc invalidate() {}\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "wip cache")
+	repo.write("store.go", "package main\n\nfunc store() {}\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "wip store")
+	indexHistory(t)
+
+	if _, err := amendHead(context.Background(), repo.config(), commitOptions{}, false); err != nil {
+		t.Fatalf("amendHead: %v", err)
+	}
+	if _, err := rewordRange(context.Background(), base, repo.config(), commitOptions{}, false); err != nil {
+		t.Fatalf("rewordRange: %v", err)
+	}
+
+	prompts := server.receivedPrompts()
+	if len(prompts) != 3 {
+		t.Fatalf("got %d prompts, want one for the amend and two for the reword", len(prompts))
+	}
+	if strings.Contains(prompts[0], "Message: wip store") {
+		t.Errorf("amend prompt offers the message being replaced as an example:\n%s", prompts[0])
+	}
+	// Commits outside the rewrite are still fair examples
+	if !strings.Contains(prompts[0], "Message: wip cache") {
+		t.Errorf("amend prompt lacks the earlier commit as an example:\n%s", prompts[0])
+	}
+	for i, prompt := range prompts[1:] {
+		if strings.Contains(prompt, "Message: wip") || strings.Contains(prompt, "Message: Added cache") {
+			t.Errorf("reword prompt %d offers a message being rewritten as an example:\n%s", i, prompt)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of operation git can stop in the middle of
+const (
+	opMerge      = "merge"
+	opRebase     = "rebase"
+	opCherryPick = "cherry-pick"
+	opRevert     = "revert"
+)
+
+// A merge, rebase, cherry-pick or revert waiting to be completed
+type inProgress struct {
+	Kind   string
+	GitDir string
+	Branch string // Branch being rebased, empty for other operations
+}
+
+// Find the operation in progress from git's state files, or nil if there is none.
+// A rebase is checked first since its steps leave cherry-pick state behind too.
+func detectInProgress(ctx context.Context) (*inProgress, error) {
+	out, err := gitClient.Run(ctx, "rev-parse", "--absolute-git-dir")
+	if err != nil {
+		return nil, fmt.Errorf("Error locating git directory: %v", err)
+	}
+	gitDir := strings.TrimSpace(out)
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(gitDir, name))
+		return err == nil
+	}
+
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if exists(dir) {
+			op := &inProgress{Kind: opRebase, GitDir: gitDir}
+			if head, err := os.ReadFile(filepath.Join(gitDir, dir, "head-name")); err == nil {
+				op.Branch = strings.TrimPrefix(strings.TrimSpace(string(head)), "refs/heads/")
+			}
+			return op, nil
+		}
+	}
+	switch {
+	case exists("MERGE_HEAD"):
+		return &inProgress{Kind: opMerge, GitDir: gitDir}, nil
+	case exists("CHERRY_PICK_HEAD"):
+		return &inProgress{Kind: opCherryPick, GitDir: gitDir}, nil
+	case exists("REVERT_HEAD"):
+		return &inProgress{Kind: opRevert, GitDir: gitDir}, nil
+	}
+	return nil, nil
+}
+
+// Refuse to rewrite history while git is in the middle of another operation
+func refuseInProgress(ctx context.Context) error {
+	op, err := detectInProgress(ctx)
+	if err != nil {
+		return err
+	}
+	if op != nil {
+		return fmt.Errorf("A %s is in progress; finish or abort it first", op.Kind)
+	}
+	return nil
+}
+
+// Get the paths the index still records as conflicted
+func unmergedPaths(ctx context.Context) ([]string, error) {
+	out, err := gitClient.Run(ctx, "diff", "--name-only", "--diff-filter=U")
+	if err != nil {
+		return nil, fmt.Errorf("Error listing conflicted files: %v", err)
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	for _, path := range strings.Split(strings.TrimSpace(out), "\n") {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// Check a working tree file for leftover conflict markers
+func hasConflictMarkers(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true
+		}
+	}
+	return false
+}
+
+// Refuse to go on while a conflicted file still has conflict markers.
+// Conflicted files that were fixed but not added are fine, since the run stages them.
+// Returns the conflicted paths.
+func checkConflicts(ctx context.Context, op *inProgress) ([]string, error) {
+	paths, err := unmergedPaths(ctx)
+	if err != nil {
+		return nil, err
+	}
+	root, err := repoRoot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var unresolved []string
+	for _, path := range paths {
+		if hasConflictMarkers(filepath.Join(root, filepath.FromSlash(path))) {
+			unresolved = append(unresolved, path)
+		}
+	}
+	if len(unresolved) > 0 {
+		return nil, fmt.Errorf("A %s is in progress with unresolved conflicts in: %s", op.Kind, strings.Join(unresolved, ", "))
+	}
+	return paths, nil
+}
+
+// Read the message git prepared for the operation, with its comment lines
+// removed, and the files it lists under "# Conflicts:"
+func preparedMessage(op *inProgress) (string, []string) {
+	data, err := os.ReadFile(filepath.Join(op.GitDir, "MERGE_MSG"))
+	if err != nil {
+		return "", nil
+	}
+
+	var lines, conflicts []string
+	inConflicts := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+			continue
+		}
+		comment := strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		switch {
+		case comment == "Conflicts:":
+			inConflicts = true
+		case inConflicts && comment != "":
+			conflicts = append(conflicts, comment)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), conflicts
+}
+
+// Build the message that completes a merge, cherry-pick or revert: git's
+// prepared message, plus a generated note on how any conflicts were resolved
+func completionMessage(ctx context.Context, op *inProgress, conflicts []string) (string, error) {
+	msg, listed := preparedMessage(op)
+	if msg == "" {
+		return "", fmt.Errorf("No prepared message for the %s in MERGE_MSG", op.Kind)
+	}
+
+	for _, path := range listed {
+		if !containsString(conflicts, path) {
+			conflicts = append(conflicts, path)
+		}
+	}
+	if len(conflicts) == 0 {
+		return msg, nil
+	}
+
+	// Compare the resolved files against our side to see what the resolution did
+	args := append([]string{"diff", "--cached", "--"}, conflicts...)
+	resolved, err := streamDiffChanges(ctx, args...)
+	if err != nil {
+		return "", fmt.Errorf("Error reading conflict resolutions: %v", err)
+	}
+	summary := fmt.Sprintf("Conflicts from a %s were resolved in: %s\n\n%s",
+		op.Kind, strings.Join(conflicts, ", "), formatChangeSummary(resolved))
+	resolution, err := generateCommitMessage(ctx, summary, "")
+	if err != nil {
+		return "", err
+	}
+
+	var body strings.Builder
+	body.WriteString(msg)
+	body.WriteString("\n\n")
+	body.WriteString(resolution)
+	body.WriteString("\n\nConflicts:\n")
+	for _, path := range conflicts {
+		body.WriteString("\t")
+		body.WriteString(path)
+		body.WriteByte('\n')
+	}
+	return strings.TrimSpace(body.String()), nil
+}
+
+// Move an interrupted rebase on to its next step after committing this one
+func continueRebase(ctx context.Context) error {
+	// Never stop to edit a message; the step was just committed
+	if _, err := gitClient.RunEnv(ctx, []string{"GIT_EDITOR=true"}, "rebase", "--continue"); err != nil {
+		return fmt.Errorf("The rebase stopped again: %v", err)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
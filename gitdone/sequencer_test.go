@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// Make main and feature change the same line of config.go and start merging
+// feature, leaving a conflict
+func startConflictedMerge(t *testing.T, repo *testRepo) {
+	t.Helper()
+	repo.write("config.go", "package main\n\nconst limit = 1\n")
+	repo.git("add", ".")
+	repo.git("commit", "-m", "Added limit")
+	repo.git("checkout", "-q", "-b", "feature")
+	repo.write("config.go", "package main\n\nconst limit = 2\n")
+	repo.git("commit", "-qam", "Raised limit")
+	repo.git("checkout", "-q", "main")
+	repo.write("config.go", "package main\n\nconst limit = 3\n")
+	repo.git("commit", "-qam", "Set limit to three")
+
+	cmd := exec.Command("git", "merge", "feature")
+	cmd.Dir = repo.Dir
+	if err := cmd.Run(); err == nil {
+		t.Fatal("merge did not conflict")
+	}
+}
+
+func TestRunRefusesUnresolvedConflicts(t *testing.T) {
+	repo := newTestRepo(t)
+	server := newFakeModelServer(t, "Resolved limit")
+	startConflictedMerge(t, repo)
+	head := repo.git("rev-parse", "HEAD")
+
+	err := runGitdone(context.Background(), repo.config(), commitOptions{}, newRunResult())
+	if code := exitCode(err); code != exitConflict {
+		t.Fatalf("exit code = %d (%v), want %d", code, err, exitConflict)
+	}
+	if got := repo.git("rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD moved to %s", got)
+	}
+	if len(server.receivedPrompts()) != 0 {
+		t.Error("model was called with unresolved conflicts")
+	}
+}
+
+func TestRunCompletesResolvedMerge(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Resolved config.go by keeping limit 3")
+	startConflictedMerge(t, repo)
+	repo.write("config.go", "package main\n\nconst limit = 3\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	if parents := strings.Fields(repo.git("log", "-1", "--format=%P")); len(parents) != 2 {
+		t.Errorf("commit has %d parents, want a merge commit", len(parents))
+	}
+	want := "Merge branch 'feature'\n\nResolved config.go by keeping limit 3\n\nConflicts:\n\tconfig.go"
+	if got := repo.git("log", "-1", "--format=%B"); got != want {
+		t.Errorf("commit message = %q, want %q", got, want)
+	}
+	if !result.Pushed {
+		t.Error("merge commit was not pushed")
+	}
+}
+
+func TestRunContinuesRebase(t *testing.T) {
+	repo := newTestRepo(t)
+	newFakeModelServer(t, "Combined both limits")
+	startConflictedMerge(t, repo)
+	repo.git("merge", "--abort")
+	repo.git("checkout", "-q", "feature")
+
+	cmd := exec.Command("git", "rebase", "main")
+	cmd.Dir = repo.Dir
+	if err := cmd.Run(); err == nil {
+		t.Fatal("rebase did not conflict")
+	}
+	repo.write("config.go", "package main\n\nconst limit = 5\n")
+
+	result := newRunResult()
+	if err := runGitdone(context.Background(), repo.config(), commitOptions{}, result); err != nil {
+		t.Fatalf("runGitdone: %v", err)
+	}
+
+	if op, err := detectInProgress(context.Background()); err != nil || op != nil {
+		t.Errorf("rebase still in progress: %+v, %v", op, err)
+	}
+	if got := repo.git("rev-parse", "--abbrev-ref", "HEAD"); got != "feature" {
+		t.Errorf("checked out %q after the rebase, want feature", got)
+	}
+	if got := repo.git("log", "-1", "--format=%s"); got != "Combined both limits" {
+		t.Errorf("commit subject = %q", got)
+	}
+	if result.Pushed {
+		t.Error("rebased branch was pushed")
+	}
+}
@@ -114,7 +114,7 @@ go mod tidy
 # Build the Go program
 Write-Color "Building gitdone.exe..." Yellow
 try {
-    go build -o gitdone.exe $gitdoneGoPath
+    go build -o gitdone.exe .
     if (-not (Test-Path ".\gitdone.exe")) {
         Handle-Error "Build completed but gitdone.exe not found"
     }
@@ -65,7 +65,7 @@ go mod tidy || log_error "Failed to tidy Go module."
 
 # Build the Go program
 log_info "Building the gitdone binary..."
-go build -o gitdone "$SCRIPT_DIR/gitdone.go" || log_error "Failed to build gitdone binary."
+go build -o gitdone . || log_error "Failed to build gitdone binary."
 
 # Find a writable directory in PATH
 log_info "Searching for a writable directory in PATH..."
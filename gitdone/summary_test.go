@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+const hunkDiff = `diff --git a/server.go b/server.go
+index 1111111..2222222 100644
+--- a/server.go
++++ b/server.go
+@@ -10,7 +10,8 @@ func (s *Server) Start() error {
+ 	addr := s.addr
+-	if addr == "" {
++	if addr == "" || s.force {
++		addr = ":8080"
+ 	}
+@@ -40,3 +41,3 @@ func (s *Server) Stop() {
+-	s.done <- true
++	close(s.done)
+`
+
+func TestSummaryIncludesHunkContextAndCounts(t *testing.T) {
+	files := parseDiffChanges(hunkDiff)
+	if len(files) != 1 {
+		t.Fatalf("parsed %d files, want 1", len(files))
+	}
+	if files[0].Added != 3 || files[0].Removed != 2 {
+		t.Errorf("counts = +%d -%d, want +3 -2", files[0].Added, files[0].Removed)
+	}
+
+	summary := formatChangeSummary(files)
+	for _, want := range []string{
+		"In server.go (+3 -2):",
+		"* Functions: func (s *Server) Start() error; func (s *Server) Stop()",
+		`Added: 	if addr == "" || s.force {`,
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary missing %q:\n%s", want, summary)
+		}
+	}
+}
+
+func TestSummaryRanksFilesWhenOverBudget(t *testing.T) {
+	small := &fileChanges{Path: "small.go", Added: 1, Changes: []string{"Added: return nil"}}
+	large := &fileChanges{Path: "large.go", Added: 200, Removed: 50}
+	for i := 0; i < 20; i++ {
+		large.Changes = append(large.Changes, fmt.Sprintf("Added: func handler%d() {", i))
+	}
+
+	summary := formatChangeSummaryWithin([]*fileChanges{small, large}, 600)
+	if strings.Index(summary, "In large.go") > strings.Index(summary, "In small.go") {
+		t.Errorf("larger change not listed first:\n%s", summary)
+	}
+	if !strings.Contains(summary, "func handler0") {
+		t.Errorf("largest change lost its details:\n%s", summary)
+	}
+	if !strings.Contains(summary, "In small.go (+1 -0):") || strings.Contains(summary, "return nil") {
+		t.Errorf("smaller change should keep only its heading:\n%s", summary)
+	}
+}
+
+// A diff produced on demand, so the test never holds all of it either
+type generatedDiff struct {
+	files, lines int
+	file, line   int
+	pending      string
+}
+
+func (g *generatedDiff) Read(p []byte) (int, error) {
+	for g.pending == "" {
+		switch {
+		case g.file == g.files:
+			return 0, io.EOF
+		case g.line == 0:
+			g.pending = fmt.Sprintf("diff --git a/fixture%d.json b/fixture%d.json\n--- a/fixture%d.json\n+++ b/fixture%d.json\n@@ -1,%d +1,%d @@\n",
+				g.file, g.file, g.file, g.file, g.lines, g.lines)
+			// A minified line longer than any scanner buffer
+			g.pending += "+" + strings.Repeat("x", 2<<20) + "\n"
+		case g.line <= g.lines:
+			g.pending = fmt.Sprintf("+  \"key%d\": \"regenerated value number %d\",\n", g.line, g.line)
+		}
+		g.line++
+		if g.line > g.lines+1 {
+			g.file, g.line = g.file+1, 0
+		}
+	}
+	n := copy(p, g.pending)
+	g.pending = g.pending[n:]
+	return n, nil
+}
+
+func TestReadDiffChangesKeepsMemoryBounded(t *testing.T) {
+	// About 50MB of diff
+	files, err := readDiffChanges(&generatedDiff{files: 10, lines: 100000})
+	if err != nil {
+		t.Fatalf("readDiffChanges: %v", err)
+	}
+	if len(files) != 10 {
+		t.Fatalf("got %d files, want 10", len(files))
+	}
+
+	kept := 0
+	for _, file := range files {
+		if file.Added != 100001 {
+			t.Errorf("%s: added = %d, want every line counted", file.Path, file.Added)
+		}
+		if len(file.Changes) > 10 {
+			t.Errorf("%s keeps %d changes, want at most 10 in a large diff", file.Path, len(file.Changes))
+		}
+		for _, change := range file.Changes {
+			kept += len(change)
+		}
+	}
+	if kept > 2*maxDiffSize {
+		t.Errorf("kept %d bytes of changes, want about %d at most", kept, maxDiffSize)
+	}
+}
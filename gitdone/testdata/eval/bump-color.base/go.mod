@@ -0,0 +1,5 @@
+module example.com/app
+
+go 1.21
+
+require github.com/fatih/color v1.16.0
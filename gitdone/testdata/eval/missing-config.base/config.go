@@ -0,0 +1,9 @@
+package main
+
+func parseConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeConfig(data)
+}
@@ -0,0 +1,6 @@
+package main
+
+func send(url string) error {
+	_, err := http.Get(url)
+	return err
+}
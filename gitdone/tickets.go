@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Get the name of the checked out branch
+func currentBranch(ctx context.Context) (string, error) {
+	branch, err := gitClient.Run(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", fmt.Errorf("Error getting current branch: %v", err)
+	}
+	return strings.TrimSpace(branch), nil
+}
+
+// Extract ticket keys such as PROJ-1234 from a branch name
+func extractTicketKeys(branch string, patterns []string) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ticket pattern %q: %v", pattern, err)
+		}
+		for _, key := range re.FindAllString(branch, -1) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// Add ticket keys to the commit message following the repository's placement rule
+func applyTicketKeys(msg string, keys []string, cfg *config) string {
+	var missing []string
+	for _, key := range keys {
+		if !strings.Contains(msg, key) {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return msg
+	}
+
+	if cfg.TicketPlacement == "subject" {
+		// Only the subject is rewrapped; the body keeps its own line breaks
+		subject, body, hasBody := strings.Cut(msg, "\n")
+		subject = formatCommitMessage(strings.Join(missing, ", ") + ": " + subject)
+		if !hasBody {
+			return subject
+		}
+		return subject + "\n" + body
+	}
+	return appendTrailers(msg, []string{cfg.TicketTrailer + ": " + strings.Join(missing, ", ")})
+}
+
+// Add the branch's ticket keys to the message, warning when a required key is missing
+func addTicketReferences(ctx context.Context, msg string, cfg *config) (string, error) {
+	branch, err := currentBranch(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	keys, err := extractTicketKeys(branch, cfg.TicketPatterns)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		if cfg.RequireTicket {
+			warn("Branch %s has no ticket key, but this repository requires one.\n", branch)
+		}
+		return msg, nil
+	}
+	return applyTicketKeys(msg, keys, cfg), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var trailerLine = regexp.MustCompile(`^[A-Za-z0-9-]+: \S`)
+
+// Options controlling how the commit is created
+type commitOptions struct {
+	Sign    bool     // Force signing with -S
+	NoSign  bool     // Disable signing even if commit.gpgsign is set
+	Signoff bool     // Add a DCO Signed-off-by trailer
+	Pairs   []string // Aliases of pair partners to credit with Co-authored-by
+
+	Interactive bool // Pick files and hunks to commit instead of staging everything
+	Review      bool // Review the staged diff first and stop on high-severity findings
+	Edit        bool // Open the generated message in the git editor before committing
+}
+
+// Append trailers after the message body, joining an existing trailer block if there is one
+func appendTrailers(msg string, trailers []string) string {
+	msg = strings.TrimRight(msg, "\n")
+	existing := make(map[string]bool)
+
+	paragraphs := strings.Split(msg, "\n\n")
+	last := paragraphs[len(paragraphs)-1]
+	hasBlock := len(paragraphs) > 1
+	for _, line := range strings.Split(last, "\n") {
+		if !trailerLine.MatchString(line) {
+			hasBlock = false
+		}
+		existing[line] = true
+	}
+
+	var added []string
+	for _, trailer := range trailers {
+		if !existing[trailer] {
+			existing[trailer] = true
+			added = append(added, trailer)
+		}
+	}
+	if len(added) == 0 {
+		return msg
+	}
+
+	if hasBlock {
+		return msg + "\n" + strings.Join(added, "\n")
+	}
+	return msg + "\n\n" + strings.Join(added, "\n")
+}
+
+// Get the committer identity from git config as "Name <email>"
+func gitIdentity(ctx context.Context) (string, error) {
+	name, err := gitClient.Run(ctx, "config", "user.name")
+	if err != nil {
+		return "", fmt.Errorf("Error reading user.name from git config: %v", err)
+	}
+	email, err := gitClient.Run(ctx, "config", "user.email")
+	if err != nil {
+		return "", fmt.Errorf("Error reading user.email from git config: %v", err)
+	}
+	return fmt.Sprintf("%s <%s>", strings.TrimSpace(name), strings.TrimSpace(email)), nil
+}
+
+// Build the Co-authored-by and Signed-off-by trailers requested for this commit
+func commitTrailers(ctx context.Context, opts commitOptions, cfg *config) ([]string, error) {
+	var trailers []string
+	for _, alias := range opts.Pairs {
+		partner, ok := cfg.Pairs[alias]
+		if !ok {
+			return nil, fmt.Errorf("Unknown pair partner %q, add it to \"pairs\" in %s", alias, configFileName)
+		}
+		trailers = append(trailers, "Co-authored-by: "+partner)
+	}
+
+	// Signed-off-by goes last, as the DCO expects
+	if opts.Signoff {
+		identity, err := gitIdentity(ctx)
+		if err != nil {
+			return nil, err
+		}
+		trailers = append(trailers, "Signed-off-by: "+identity)
+	}
+	return trailers, nil
+}
+
+// Get the git commit flags for signing
+func signingArgs(opts commitOptions) []string {
+	switch {
+	case opts.Sign:
+		return []string{"-S"}
+	case opts.NoSign:
+		return []string{"--no-gpg-sign"}
+	}
+	return nil
+}
+
+// Check whether this commit will be signed, following git config unless overridden
+func signingEnabled(ctx context.Context, opts commitOptions) bool {
+	if opts.Sign || opts.NoSign {
+		return opts.Sign
+	}
+	value, err := gitClient.Run(ctx, "config", "--bool", "commit.gpgsign")
+	return err == nil && strings.TrimSpace(value) == "true"
+}
+
+// Verify the signature on a commit gitdone just made
+func verifyCommitSignature(ctx context.Context, rev string) error {
+	status, err := gitClient.Run(ctx, "log", "-1", "--format=%G?", rev)
+	if err != nil {
+		return fmt.Errorf("Error checking commit signature: %v", err)
+	}
+
+	switch strings.TrimSpace(status) {
+	case "G", "U":
+		success("Verified signature on %s\n", rev)
+		return nil
+	case "N":
+		return fmt.Errorf("Commit %s was not signed", rev)
+	case "E":
+		warn("Commit is signed, but the signature could not be checked (for SSH signing, set gpg.ssh.allowedSignersFile).\n")
+		return nil
+	default:
+		return fmt.Errorf("Commit %s has a bad or untrusted signature (status %s)", rev, strings.TrimSpace(status))
+	}
+}